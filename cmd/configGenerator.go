@@ -27,12 +27,22 @@ var output, detectNic, serverPath, clientPath string
 var configAPIServerPort int
 var pretty bool
 
+// These variables are used to generate a boot configuration from an ISO
+var bootISOPath, bootISOPrefix, bootConfigName, bootHTTPAddress string
+
 func init() {
 	plunderCmd.AddCommand(plunderConfig)
 	plunderConfig.PersistentFlags().StringVarP(&output, "output", "o", "json", "Ouput type, should be either JSON or YAML")
 	plunderConfig.PersistentFlags().BoolVarP(&pretty, "pretty", "p", false, "Ouput JSON in a pretty/Human readable format")
 	plunderServerConfig.PersistentFlags().StringVarP(&detectNic, "nic", "n", "", "Build configuration for a particular network interface")
 
+	// Boot configuration from an ISO
+	plunderBootConfig.Flags().StringVar(&bootISOPath, "iso", "", "Path to an ISO to generate a boot configuration from")
+	plunderBootConfig.Flags().StringVar(&bootISOPrefix, "prefix", "", "The ISO prefix, if blank the name of the ISO is used")
+	plunderBootConfig.Flags().StringVar(&bootConfigName, "name", "", "The name of the boot configuration, if blank the ISO prefix is used")
+	plunderBootConfig.Flags().StringVarP(&detectNic, "nic", "n", "", "Network interface whose address is used for the plunder HTTP server")
+	plunderBootConfig.Flags().StringVar(&bootHTTPAddress, "addressHTTP", "", "Address of the plunder HTTP server, if blank the address of --nic is used")
+
 	// Persistent above both client functions
 	plunderAPIConfig.PersistentFlags().IntVar(&configAPIServerPort, "port", 60443, "Port that the plunder API server should use")
 
@@ -48,6 +58,8 @@ func init() {
 	// Add all sub commands to the config sub command
 	plunderConfig.AddCommand(plunderAPIConfig)
 	plunderConfig.AddCommand(plunderServerConfig)
+	plunderConfig.AddCommand(plunderBootConfig)
+	plunderConfig.AddCommand(plunderInstallerConfig)
 	plunderConfig.AddCommand(plunderDeploymentConfig)
 	plunderConfig.AddCommand(PlunderParlayConfig)
 
@@ -92,6 +104,53 @@ var plunderServerConfig = &cobra.Command{
 	},
 }
 
+// plunderBootConfig - This will generate a boot configuration by inspecting an ISO
+var plunderBootConfig = &cobra.Command{
+	Use:   "boot",
+	Short: "Generate a boot configuration from an ISO",
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetLevel(log.Level(logLevel))
+		if bootISOPath == "" {
+			cmd.Help()
+			log.Fatalln("The path to an ISO is required [--iso]")
+		}
+
+		if bootHTTPAddress == "" {
+			_, nicAddr, err := utils.FindIPAddress(detectNic)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			bootHTTPAddress = nicAddr
+		}
+
+		bc, err := services.GenerateBootConfig(bootISOPath, bootISOPrefix, bootConfigName, bootHTTPAddress)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		err = renderOutput(bc, pretty)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	},
+}
+
+// plunderInstallerConfig - This will print a built-in installer template, so that it can be used as the basis of a custom template
+var plunderInstallerConfig = &cobra.Command{
+	Use:   "installer [preseed|kickstart|vsphere|cloudinit|autoinstall|windows|autoyast]",
+	Short: "Print the built-in installer template for a config type",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetLevel(log.Level(logLevel))
+		t, err := services.DefaultInstallerTemplate(args[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Print(t)
+		return
+	},
+}
+
 // PlunderDeploymentConfig - This is for intialising a blank or partial configuration
 var plunderDeploymentConfig = &cobra.Command{
 	Use:   "deployment",
@@ -332,6 +391,8 @@ func detectServerConfig() error {
 	services.Controller.TFTPAddress = &nicAddr
 
 	*services.Controller.PXEFileName = "undionly.kpxe"
+	*services.Controller.PXEEFIFileName = "ipxe.efi"
+	*services.Controller.PXEARM64FileName = "snp.efi"
 
 	// DHCP Settings
 	services.Controller.DHCPConfig.DHCPAddress = nicAddr
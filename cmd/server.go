@@ -32,10 +32,16 @@ func init() {
 	services.Controller.TFTPAddress = PlunderServer.Flags().String("addressTFTP", "", "Address of TFTP to use, if blank will default to [addressDHCP]")
 
 	services.Controller.EnableDHCP = PlunderServer.Flags().Bool("enableDHCP", false, "Enable the DCHP Server")
+	services.Controller.ProxyDHCP = PlunderServer.Flags().Bool("proxyDHCP", false, "Run the DHCP Server in proxyDHCP mode alongside an existing DHCP server")
 	services.Controller.EnableTFTP = PlunderServer.Flags().Bool("enableTFTP", false, "Enable the TFTP Server")
 	services.Controller.EnableHTTP = PlunderServer.Flags().Bool("enableHTTP", false, "Enable the HTTP Server")
 
 	services.Controller.PXEFileName = PlunderServer.Flags().String("iPXEPath", "undionly.kpxe", "Path to an iPXE bootloader")
+	services.Controller.PXEEFIFileName = PlunderServer.Flags().String("iPXEEFIPath", "ipxe.efi", "Path to an iPXE bootloader for x86_64 UEFI clients")
+	services.Controller.PXEARM64FileName = PlunderServer.Flags().String("iPXEARM64Path", "snp.efi", "Path to an iPXE bootloader for arm64 UEFI clients")
+	services.Controller.TFTPRoot = PlunderServer.Flags().String("rootTFTP", "", "Path to a directory of files to be served by the TFTP Server")
+	services.Controller.ISODirectory = PlunderServer.Flags().String("isoDirectory", "", "Path to a directory of ISOs that can be managed through the API")
+	services.Controller.InstalledFile = PlunderServer.Flags().String("installedFile", "plunder.installed", "Path that installed deployments are persisted to, so they aren't provisioned again after a restart (blank keeps them in memory only)")
 
 	// DHCP Settings
 	PlunderServer.Flags().StringVar(&services.Controller.DHCPConfig.DHCPAddress, "addressDHCP", "", "Address to advertise leases from, ideally will be the IP address of --adapter")
@@ -43,6 +49,7 @@ func init() {
 	PlunderServer.Flags().StringVar(&services.Controller.DHCPConfig.DHCPDNS, "dns", "", "Address of DNS to use, if blank will default to [addressDHCP]")
 	PlunderServer.Flags().IntVar(&services.Controller.DHCPConfig.DHCPLeasePool, "leasecount", 20, "Amount of leases to advertise")
 	PlunderServer.Flags().StringVar(&services.Controller.DHCPConfig.DHCPStartAddress, "startAddress", "", "Start advertised address [REQUIRED]")
+	PlunderServer.Flags().StringVar(&services.Controller.DHCPConfig.DHCPLeaseFile, "leaseFile", "plunder.leases", "Path to the DHCP lease database, leases are persisted across restarts (blank keeps leases in memory only)")
 
 	//HTTP Settings
 	defaultKernel = PlunderServer.Flags().String("kernel", "", "Path to a kernel to set as the *default* kernel")
@@ -113,13 +120,16 @@ var PlunderServer = &cobra.Command{
 			log.Warnln("All services are currently disabled")
 		}
 
-		// If we've enabled DHCP, then we need to ensure a start address for the range is populated
-		if *services.Controller.EnableDHCP && services.Controller.DHCPConfig.DHCPStartAddress == "" {
-			log.Fatalln("A DHCP Start address is required")
-		}
+		// A proxyDHCP server doesn't hand out addresses, so has no need for a pool
+		if *services.Controller.ProxyDHCP == false {
+			// If we've enabled DHCP, then we need to ensure a start address for the range is populated
+			if *services.Controller.EnableDHCP && services.Controller.DHCPConfig.DHCPStartAddress == "" {
+				log.Fatalln("A DHCP Start address is required")
+			}
 
-		if services.Controller.DHCPConfig.DHCPLeasePool == 0 {
-			log.Fatalln("At least one available lease is required")
+			if services.Controller.DHCPConfig.DHCPLeasePool == 0 {
+				log.Fatalln("At least one available lease is required")
+			}
 		}
 
 		services.Controller.StartServices(deployment)
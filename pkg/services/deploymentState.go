@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The provisioning states of a server, a server moves through these states as it is provisioned
+const (
+	StateDiscovered             = "discovered"
+	StateDHCPOffered            = "dhcp-offered"
+	StateIPXEFetched            = "ipxe-fetched"
+	StateInstallerConfigFetched = "installer-config-fetched"
+	StateInstalling             = "installing"
+	StateInstalled              = "installed"
+	StateFailed                 = "failed"
+)
+
+// eventStates maps the boot events to the provisioning state that they move a server to
+var eventStates = map[string]string{
+	EventDHCPDiscover:           StateDiscovered,
+	EventDHCPOffer:              StateDHCPOffered,
+	EventIPXEFetched:            StateIPXEFetched,
+	EventInstallerConfigFetched: StateInstallerConfigFetched,
+	EventInstalling:             StateInstalling,
+	EventInstalled:              StateInstalled,
+	EventFailed:                 StateFailed,
+}
+
+// DeploymentState - is the provisioning state of a server, this is managed by plunder and isn't part of the deployment
+// configuration
+type DeploymentState struct {
+	State      string               `json:"state"`
+	Address    string               `json:"address,omitempty"` // The address the server last used
+	Updated    time.Time            `json:"updated"`
+	Timestamps map[string]time.Time `json:"timestamps,omitempty"` // When the server last entered each state
+}
+
+// deploymentStates holds the provisioning state of each deployment, indexed by the (lower case) mac address. The states
+// are only held in memory, after a restart a deployment has no state until it is seen again (other than an installed
+// deployment, which is restored from the installedFile).
+var deploymentStates = make(map[string]*DeploymentState)
+
+// deploymentStatesLock protects the deploymentStates, as they're updated by both the DHCP and HTTP servers
+var deploymentStatesLock sync.Mutex
+
+// setDeploymentState - will move a deployment to a new state. The installer will also use DHCP (and may fetch files
+// again), so these earlier states are ignored whilst a server is installing and once it is installed (until it is
+// re-provisioned).
+func setDeploymentState(mac, address, state string) error {
+	mac = strings.ToLower(mac)
+	deployment := findDeploymentFromMac(mac)
+	if deployment == nil {
+		return fmt.Errorf("Unable to find existing deployment for MAC address [%s]", mac)
+	}
+
+	deploymentStatesLock.Lock()
+	defer deploymentStatesLock.Unlock()
+
+	current, ok := deploymentStates[mac]
+	if !ok {
+		current = &DeploymentState{Timestamps: make(map[string]time.Time)}
+		// A deployment that was installed before plunder was restarted has no recorded state
+		if deployment.Installed {
+			current.State = StateInstalled
+		}
+		deploymentStates[mac] = current
+	}
+
+	switch state {
+	case StateDiscovered, StateDHCPOffered, StateIPXEFetched:
+		if current.State == StateInstalling || current.State == StateInstalled {
+			return nil
+		}
+	case StateInstallerConfigFetched:
+		if current.State == StateInstalled {
+			return nil
+		}
+	case StateInstalling:
+		if current.State == StateInstalled {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	current.State = state
+	current.Updated = now
+	current.Timestamps[state] = now
+	if address != "" {
+		current.Address = address
+	}
+	return nil
+}
+
+// resetDeploymentState - removes the state of a deployment, so that it is provisioned from the beginning
+func resetDeploymentState(mac string) {
+	deploymentStatesLock.Lock()
+	delete(deploymentStates, strings.ToLower(mac))
+	deploymentStatesLock.Unlock()
+}
+
+// getDeploymentState - returns a copy of the state of a deployment, or nil if it hasn't been seen yet
+func getDeploymentState(deployment *DeploymentConfig) *DeploymentState {
+	deploymentStatesLock.Lock()
+	defer deploymentStatesLock.Unlock()
+
+	current, ok := deploymentStates[strings.ToLower(deployment.MAC)]
+	if !ok {
+		if deployment.Installed {
+			return &DeploymentState{State: StateInstalled}
+		}
+		return nil
+	}
+	state := *current
+	state.Timestamps = make(map[string]time.Time, len(current.Timestamps))
+	for k, v := range current.Timestamps {
+		state.Timestamps[k] = v
+	}
+	return &state
+}
+
+// deploymentWithState - returns a copy of a deployment along with its provisioning state
+func deploymentWithState(deployment *DeploymentConfig) DeploymentConfig {
+	d := *deployment
+	d.State = getDeploymentState(deployment)
+	return d
+}
+
+// installedFile is the path that the installed deployments are written to, so that an installed server isn't
+// re-provisioned when plunder is restarted (blank keeps them in memory only)
+var installedFile string
+
+// installedDeployments holds the (lower case) mac addresses of the installed deployments, it is guarded by the
+// deploymentsLock
+var installedDeployments = make(map[string]bool)
+
+// installedDatabase is the on-disk representation of the installed deployments
+type installedDatabase struct {
+	Installed []string `json:"installed"`
+}
+
+// loadInstalled will read the installed deployments from disk, they're marked as installed when their deployment is loaded
+func loadInstalled(path string) error {
+	deploymentsLock.Lock()
+	defer deploymentsLock.Unlock()
+
+	installedFile = path
+	if installedFile == "" {
+		log.Debugf("No installed file specified, installed deployments will not be persisted")
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(installedFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Infof("No existing installed deployments found at [%s]", installedFile)
+			return nil
+		}
+		return err
+	}
+
+	var db installedDatabase
+	err = json.Unmarshal(b, &db)
+	if err != nil {
+		return fmt.Errorf("Unable to parse installed deployments [%s]\n %s", installedFile, err.Error())
+	}
+	for i := range db.Installed {
+		installedDeployments[strings.ToLower(db.Installed[i])] = true
+	}
+
+	log.Infof("Restored [%d] installed deployments from [%s]", len(installedDeployments), installedFile)
+	return nil
+}
+
+// setInstalled - records whether a deployment is installed and writes the installed deployments to disk, the caller must
+// hold the deployments lock
+func setInstalled(mac string, installed bool) {
+	mac = strings.ToLower(mac)
+	if installedDeployments[mac] == installed {
+		return
+	}
+	if installed {
+		installedDeployments[mac] = true
+	} else {
+		delete(installedDeployments, mac)
+	}
+
+	err := saveInstalled()
+	if err != nil {
+		log.Errorf("Unable to save installed deployments [%v]", err)
+	}
+}
+
+// saveInstalled will write the installed deployments to disk, the caller must hold the deployments lock
+func saveInstalled() error {
+	if installedFile == "" {
+		return nil
+	}
+
+	db := installedDatabase{Installed: make([]string, 0, len(installedDeployments))}
+	for mac := range installedDeployments {
+		db.Installed = append(db.Installed, mac)
+	}
+	sort.Strings(db.Installed)
+
+	b, err := json.Marshal(db)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and then rename it, this ensures that a crash mid-write won't leave a corrupt file
+	tmpFile, err := ioutil.TempFile(filepath.Dir(installedFile), ".plunder-installed")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(b)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), installedFile)
+}
@@ -3,10 +3,14 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"reflect"
 	"strings"
+	"sync"
 
 	"plunder-app/plunder/pkg/utils"
 
+	dhcp "github.com/krolaw/dhcp4"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,6 +20,10 @@ var DefaultBootType string
 // This stores the mapping for a url to the data /macaddress.file => data
 var httpPaths map[string]string
 
+// deploymentsLock guards the Deployments and httpPaths, which are updated by the API server and installer callbacks
+// whilst the DHCP and HTTP servers are reading them
+var deploymentsLock sync.RWMutex
+
 func init() {
 	// Initialise the paths map
 	httpPaths = make(map[string]string)
@@ -52,6 +60,15 @@ func rebuildConfiguration(updateConfig *DeploymentConfigurationFile) error {
 		// inMemBOOTyConfig is a custom configuration that matches kernel/initrd & cmdline and is 00:11:22:33:44:55.bty
 		var inMemBOOTyConfig string
 
+		// inMemHostFiles are files (e.g. the cloud-init NoCloud datasource) indexed by filename and are 00:11:22:33:44:55/user-data
+		var inMemHostFiles map[string]string
+
+		// inMemIgnition is a custom Ignition configuration for CoreOS and is 00:11:22:33:44:55.ign
+		var inMemIgnition string
+
+		// inMemAutoYaST is a custom AutoYaST profile for SUSE and is 00:11:22:33:44:55.xml
+		var inMemAutoYaST string
+
 		// We need to move all ":" to "-" to make life a little easier for filesystems and internet standards
 		dashMac := strings.Replace(updateConfig.Configs[i].MAC, ":", "-", -1)
 
@@ -68,6 +85,17 @@ func rebuildConfiguration(updateConfig *DeploymentConfigurationFile) error {
 		// Ensure this entry has the correct mapping
 		updateConfig.Configs[i].ConfigBoot = *bootConfig
 
+		// The provisioning state is managed by plunder, so it is never part of the configuration
+		updateConfig.Configs[i].State = nil
+
+		// Ensure any DHCP options for this host can be encoded before they're handed out
+		err := applyOptions(dhcp.Options{}, updateConfig.Configs[i].DHCPOptions)
+		if err != nil {
+			errorString := fmt.Errorf("Host [%s] has invalid DHCP options, stopping config update\n %s", updateConfig.Configs[i].MAC, err.Error())
+			log.Errorln(errorString)
+			return errorString
+		}
+
 		// This will populate anything missing from the global configuration
 		updateConfig.Configs[i].ConfigHost.PopulateFromGlobalConfiguration(updateConfig.GlobalServerConfig)
 
@@ -86,27 +114,104 @@ func rebuildConfiguration(updateConfig *DeploymentConfigurationFile) error {
 		case "preseed":
 			inMemipxeConfig = utils.IPXEPreeseed(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, bootConfig.Cmdline)
 			log.Debugf("Generating preseed ipxeConfig for configName [%s]", dashMac)
-			inMemBootConfig = updateConfig.Configs[i].ConfigHost.BuildPreeSeedConfig()
+			inMemBootConfig, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
 
 		case "kickstart":
 			inMemipxeConfig = utils.IPXEKickstart(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, bootConfig.Cmdline)
 			log.Debugf("Generating kickstart ipxeConfig for configName [%s]", dashMac)
-			inMemBootConfig = updateConfig.Configs[i].ConfigHost.BuildKickStartConfig()
+			inMemBootConfig, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
 
 		case "vsphere":
 			inMemipxeConfig = utils.IPXEVSphere(HttpAddress, bootConfig.Kernel, bootConfig.Cmdline)
 			log.Debugf("Generating vsphere ipxeConfig for configName [%s]", dashMac)
-			inMemBootConfig = updateConfig.Configs[i].ConfigHost.BuildESXiConfig()
-			imMemESXiKickstart = updateConfig.Configs[i].ConfigHost.BuildESXiKickStart()
+			inMemBootConfig, err = bootConfig.buildESXiBootCfg(updateConfig.Configs[i])
+			if err == nil {
+				imMemESXiKickstart, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
+			}
 
 		case "booty":
 			inMemipxeConfig = utils.IPXEBOOTy(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, bootConfig.Cmdline)
 			log.Debugf("Generating booty ipxeConfig for configName [%s]", dashMac)
-			inMemBOOTyConfig = updateConfig.Configs[i].ConfigHost.BuildBOOTYconfig()
+			inMemBOOTyConfig = updateConfig.Configs[i].ConfigHost.BuildBOOTYconfig(dashMac)
+
+		case "cloudinit":
+			inMemipxeConfig = utils.IPXECloudInit(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, bootConfig.Cmdline)
+			log.Debugf("Generating cloud-init ipxeConfig for configName [%s]", dashMac)
+			var userData string
+			userData, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
+			inMemHostFiles = map[string]string{
+				"meta-data":      updateConfig.Configs[i].ConfigHost.BuildCloudInitMetaData(updateConfig.Configs[i].MAC),
+				"user-data":      userData,
+				"vendor-data":    cloudInitVendorData,
+				"network-config": updateConfig.Configs[i].ConfigHost.BuildCloudInitNetworkConfig(updateConfig.Configs[i].MAC),
+			}
+
+		case "autoinstall":
+			inMemipxeConfig = utils.IPXEAutoinstall(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, bootConfig.ISOPrefix, bootConfig.Cmdline)
+			log.Debugf("Generating autoinstall ipxeConfig for configName [%s]", dashMac)
+			// The autoinstall configuration is read by the installer as cloud-init user-data
+			var userData string
+			userData, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
+			inMemHostFiles = map[string]string{
+				"meta-data":   updateConfig.Configs[i].ConfigHost.BuildCloudInitMetaData(updateConfig.Configs[i].MAC),
+				"user-data":   userData,
+				"vendor-data": cloudInitVendorData,
+			}
+
+		case "ignition":
+			installDevice := updateConfig.Configs[i].ConfigHost.DestinationDevice
+			if installDevice == "" {
+				installDevice = ignitionDefaultInstallDevice
+			}
+			inMemipxeConfig = utils.IPXEIgnition(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, installDevice, updateConfig.Configs[i].ConfigHost.SourceImage, bootConfig.Cmdline)
+			log.Debugf("Generating ignition ipxeConfig for configName [%s]", dashMac)
+			if bootConfig.hasInstallerTemplate() {
+				inMemIgnition, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
+				if err == nil && !json.Valid([]byte(inMemIgnition)) {
+					err = fmt.Errorf("Boot Config [%s] installer template doesn't render valid JSON", bootConfig.ConfigName)
+				}
+			} else {
+				inMemIgnition, err = updateConfig.Configs[i].ConfigHost.BuildIgnitionConfig(updateConfig.Configs[i].MAC)
+			}
+
+		case "autoyast":
+			installURL := updateConfig.Configs[i].ConfigHost.autoyastInstallURL(bootConfig.ISOPrefix)
+			inMemipxeConfig = utils.IPXEAutoYaST(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, installURL, bootConfig.Cmdline)
+			log.Debugf("Generating autoyast ipxeConfig for configName [%s]", dashMac)
+			inMemAutoYaST, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
+
+		case "windows":
+			inMemipxeConfig = utils.IPXEWindows(HttpAddress, bootConfig.Kernel, bootConfig.ISOPrefix, bootConfig.Cmdline)
+			log.Debugf("Generating windows ipxeConfig for configName [%s]", dashMac)
+			inMemHostFiles, err = bootConfig.buildWindowsConfig(updateConfig.Configs[i])
 
 		default:
 			log.Debugf("Generating default ipxeConfig for configName [%s]", updateConfig.Configs[i].ConfigBoot.ConfigName)
 			inMemipxeConfig = utils.IPXEAnyBoot(HttpAddress, bootConfig.Kernel, bootConfig.Initrd, bootConfig.Cmdline)
+			// The default iPXE script passes a url to the .cfg, which can be populated from an installer template
+			if bootConfig.hasInstallerTemplate() {
+				inMemBootConfig, err = bootConfig.buildInstallerConfig(updateConfig.Configs[i])
+			}
+		}
+
+		if err != nil {
+			errorString := fmt.Errorf("Host [%s] has an invalid installer configuration, stopping config update\n %s", updateConfig.Configs[i].MAC, err.Error())
+			log.Errorln(errorString)
+			return errorString
+		}
+
+		// The boot configuration may replace the built-in iPXE script with its own template
+		inMemipxeConfig, err = bootConfig.buildIPXE(updateConfig.Configs[i].MAC, updateConfig.Configs[i].ConfigHost, inMemipxeConfig)
+		if err != nil {
+			errorString := fmt.Errorf("Host [%s] has an invalid iPXE template, stopping config update\n %s", updateConfig.Configs[i].MAC, err.Error())
+			log.Errorln(errorString)
+			return errorString
+		}
+
+		// An installed server boots from its local disk until it is re-provisioned
+		if updateConfig.Configs[i].Installed {
+			log.Debugf("Host [%s] is installed, generating local boot ipxeConfig", dashMac)
+			inMemipxeConfig = utils.IPXELocalBoot()
 		}
 
 		// Build the configuration that is passed to iPXE on boot
@@ -150,6 +255,36 @@ func rebuildConfiguration(updateConfig *DeploymentConfigurationFile) error {
 			httpPaths[path] = inMemBOOTyConfig
 		}
 
+		// Build an Ignition configuration that is passed to the CoreOS installer
+		if inMemIgnition != "" {
+			path := fmt.Sprintf("/%s.ign", dashMac)
+			if _, ok := httpPaths[path]; !ok {
+				// Only create the handler if one doesn't exist
+				serveMux.HandleFunc(path, rootHandler)
+			}
+			httpPaths[path] = inMemIgnition
+		}
+
+		// Build an AutoYaST profile that is passed to the SUSE installer
+		if inMemAutoYaST != "" {
+			path := fmt.Sprintf("/%s.xml", dashMac)
+			if _, ok := httpPaths[path]; !ok {
+				// Only create the handler if one doesn't exist
+				serveMux.HandleFunc(path, rootHandler)
+			}
+			httpPaths[path] = inMemAutoYaST
+		}
+
+		// Build the files that are read from a directory for the host (the cloud-init NoCloud datasource or WinPE files)
+		for filename, content := range inMemHostFiles {
+			path := fmt.Sprintf("/%s/%s", dashMac, filename)
+			if _, ok := httpPaths[path]; !ok {
+				// Only create the handler if one doesn't exist
+				serveMux.HandleFunc(path, rootHandler)
+			}
+			httpPaths[path] = content
+		}
+
 	}
 	if len(updateConfig.Configs) == 0 {
 		// No changes, leave as is (with a warning)
@@ -172,8 +307,50 @@ func UpdateDeploymentConfig(rawDeploymentConfig []byte) error {
 	if err != nil {
 		return err
 	}
-	return rebuildConfiguration(updateConfig)
 
+	deploymentsLock.Lock()
+	keepInstalled(updateConfig.Configs)
+
+	// Keep the existing deployments, so that an event can be published for each deployment that has changed
+	existing := Deployments.Configs
+
+	err = rebuildConfiguration(updateConfig)
+	updated := Deployments.Configs
+	deploymentsLock.Unlock()
+	if err != nil {
+		return err
+	}
+	// An empty configuration leaves the existing deployments in place, so compare against what is now deployed
+	publishDeploymentChanges(existing, updated)
+	return nil
+}
+
+// publishDeploymentChanges - publishes an added, updated or deleted event for each deployment that differs between the
+// existing and updated deployments (matched by their mac address)
+func publishDeploymentChanges(existing, updated []DeploymentConfig) {
+	previous := make(map[string]DeploymentConfig, len(existing))
+	for i := range existing {
+		previous[strings.ToLower(existing[i].MAC)] = existing[i]
+	}
+
+	for i := range updated {
+		mac := strings.ToLower(updated[i].MAC)
+		old, ok := previous[mac]
+		delete(previous, mac)
+		switch {
+		case !ok:
+			publishEvent(EventDeploymentAdded, updated[i].MAC, updated[i].ConfigHost.IPAddress, updated[i].ConfigName)
+		case !reflect.DeepEqual(old, updated[i]):
+			publishEvent(EventDeploymentUpdated, updated[i].MAC, updated[i].ConfigHost.IPAddress, updated[i].ConfigName)
+		}
+	}
+
+	// Anything that remains is no longer part of the configuration
+	for i := range existing {
+		if _, ok := previous[strings.ToLower(existing[i].MAC)]; ok {
+			publishEvent(EventDeploymentDeleted, existing[i].MAC, existing[i].ConfigHost.IPAddress, existing[i].ConfigName)
+		}
+	}
 }
 
 // AddDeployment - This function will add a new deployment to the deployment configuration
@@ -185,12 +362,12 @@ func AddDeployment(rawDeployment []byte) error {
 	if err != nil {
 		return fmt.Errorf("Unable to parse deployment configuration")
 	}
+
+	deploymentsLock.Lock()
 	// Find the original deployment via it's mac address
-	for i := range Deployments.Configs {
-		// Compare this deployment to the one we're looking for
-		if Deployments.Configs[i].MAC == newDeployment.MAC {
-			return fmt.Errorf("Duplicate entry for MAC address [%s]", newDeployment.MAC)
-		}
+	if getDeployment(newDeployment.MAC) != nil {
+		deploymentsLock.Unlock()
+		return fmt.Errorf("Duplicate entry for MAC address [%s]", newDeployment.MAC)
 	}
 	// We will now duplicate our configuration
 	updateConfig := Deployments
@@ -200,16 +377,30 @@ func AddDeployment(rawDeployment []byte) error {
 	copy(updateConfig.Configs, Deployments.Configs)
 	// Append our new configuration into our new copy
 	updateConfig.Configs = append(updateConfig.Configs, newDeployment)
+	keepInstalled(updateConfig.Configs[len(updateConfig.Configs)-1:])
+
+	// Parse the new configuration
+	err = rebuildConfiguration(&updateConfig)
+	deploymentsLock.Unlock()
+	if err != nil {
+		return err
+	}
 
 	// Remove the deployment from the unleased addresses
 	controller.DelUnLeased(newDeployment.MAC)
-
-	// Parse the new configuration
-	return rebuildConfiguration(&updateConfig)
+	publishEvent(EventDeploymentAdded, newDeployment.MAC, newDeployment.ConfigHost.IPAddress, newDeployment.ConfigName)
+	return nil
 }
 
 // GetDeployment - This function will add a new deployment to the deployment configuration
 func GetDeployment(macAddress string) *DeploymentConfig {
+	deploymentsLock.RLock()
+	defer deploymentsLock.RUnlock()
+	return getDeployment(macAddress)
+}
+
+// getDeployment - returns the deployment for a mac address, the caller must hold the deployments lock
+func getDeployment(macAddress string) *DeploymentConfig {
 	// Iterate through all the deployments
 	for i := range Deployments.Configs {
 		if macAddress == Deployments.Configs[i].MAC {
@@ -234,6 +425,7 @@ func UpdateDeployment(macAddress string, rawDeployment []byte) error {
 		macAddress = newDeployment.MAC
 	}
 
+	deploymentsLock.Lock()
 	// We will now duplicate our configuration
 	updateConfig := Deployments
 	// We will need to create space to copy the existing configurations over
@@ -249,17 +441,26 @@ func UpdateDeployment(macAddress string, rawDeployment []byte) error {
 			updateConfig.Configs = append(updateConfig.Configs[:i], updateConfig.Configs[i+1:]...)
 			// Append our new configuration into our new copy
 			updateConfig.Configs = append(updateConfig.Configs, newDeployment)
+			keepInstalled(updateConfig.Configs[len(updateConfig.Configs)-1:])
 
 			// Parse the new configuration
-			return rebuildConfiguration(&updateConfig)
+			err = rebuildConfiguration(&updateConfig)
+			deploymentsLock.Unlock()
+			if err != nil {
+				return err
+			}
+			publishEvent(EventDeploymentUpdated, newDeployment.MAC, newDeployment.ConfigHost.IPAddress, newDeployment.ConfigName)
+			return nil
 		}
 	}
+	deploymentsLock.Unlock()
 	return fmt.Errorf("Unable to find existing deployment for MAC address [%s]", macAddress)
 }
 
 // DeleteDeploymentMac - This function will delete a deployment based upon it's mac Address
 func DeleteDeploymentMac(macAddress string, rawDeployment []byte) error {
 
+	deploymentsLock.Lock()
 	// We will now duplicate our configuration
 	updateConfig := Deployments
 	// We will need to create space to copy the existing configurations over
@@ -278,12 +479,28 @@ func DeleteDeploymentMac(macAddress string, rawDeployment []byte) error {
 				delete(httpPaths, fmt.Sprintf("%s.ipxe", updateConfig.Configs[i].MAC))
 			}
 
+			// Keep the existing deployments for the event, the deployment is only deleted if they are replaced
+			existing := Deployments.Configs
+			mac := updateConfig.Configs[i].MAC
+
 			// Remove the old matching configuration
 			updateConfig.Configs = append(updateConfig.Configs[:i], updateConfig.Configs[i+1:]...)
 			// Parse the new configuration
-			return rebuildConfiguration(&updateConfig)
+			err := rebuildConfiguration(&updateConfig)
+			if err == nil && getDeployment(mac) == nil {
+				// A deleted server is provisioned again if its deployment is added back
+				setInstalled(mac, false)
+			}
+			updated := Deployments.Configs
+			deploymentsLock.Unlock()
+			if err != nil {
+				return err
+			}
+			publishDeploymentChanges(existing, updated)
+			return nil
 		}
 	}
+	deploymentsLock.Unlock()
 	return fmt.Errorf("Unable to find existing deployment for Address [%s]", macAddress)
 
 }
@@ -291,6 +508,7 @@ func DeleteDeploymentMac(macAddress string, rawDeployment []byte) error {
 // DeleteDeploymentAddress - This function will delete a deployment based upon it's IP Address
 func DeleteDeploymentAddress(address string, rawDeployment []byte) error {
 
+	deploymentsLock.Lock()
 	// We will now duplicate our configuration
 	updateConfig := Deployments
 	// We will need to create space to copy the existing configurations over
@@ -309,16 +527,83 @@ func DeleteDeploymentAddress(address string, rawDeployment []byte) error {
 				delete(httpPaths, fmt.Sprintf("%s.ipxe", updateConfig.Configs[i].MAC))
 			}
 
+			// Keep the existing deployments for the event, the deployment is only deleted if they are replaced
+			existing := Deployments.Configs
+			mac := updateConfig.Configs[i].MAC
+
 			// Remove the old matching configuration
 			updateConfig.Configs = append(updateConfig.Configs[:i], updateConfig.Configs[i+1:]...)
 			// Parse the new configuration
-			return rebuildConfiguration(&updateConfig)
+			err := rebuildConfiguration(&updateConfig)
+			if err == nil && getDeployment(mac) == nil {
+				// A deleted server is provisioned again if its deployment is added back
+				setInstalled(mac, false)
+			}
+			updated := Deployments.Configs
+			deploymentsLock.Unlock()
+			if err != nil {
+				return err
+			}
+			publishDeploymentChanges(existing, updated)
+			return nil
 		}
 	}
+	deploymentsLock.Unlock()
 	return fmt.Errorf("Unable to find existing deployment for Address [%s]", address)
 
 }
 
+// SetDeploymentInstalled - This function will mark a deployment as installed, so that it boots from its local disk, or
+// as not installed so that it is re-provisioned
+func SetDeploymentInstalled(macAddress string, installed bool) error {
+
+	deploymentsLock.Lock()
+	// We will now duplicate our configuration
+	updateConfig := Deployments
+	// We will need to create space to copy the existing configurations over
+	updateConfig.Configs = make([]DeploymentConfig, len(Deployments.Configs))
+	// Copy our existing configurations into the new configuration
+	copy(updateConfig.Configs, Deployments.Configs)
+
+	// Find the deployment via it's mac address
+	for i := range updateConfig.Configs {
+		if strings.ToLower(updateConfig.Configs[i].MAC) == strings.ToLower(macAddress) {
+			updateConfig.Configs[i].Installed = installed
+
+			// Parse the new configuration
+			err := rebuildConfiguration(&updateConfig)
+			if err == nil {
+				setInstalled(macAddress, installed)
+			}
+			deploymentsLock.Unlock()
+			if err != nil {
+				return err
+			}
+			eventType := EventInstalled
+			if !installed {
+				eventType = EventReprovisioned
+				resetDeploymentState(updateConfig.Configs[i].MAC)
+			}
+			publishEvent(eventType, updateConfig.Configs[i].MAC, updateConfig.Configs[i].ConfigHost.IPAddress, updateConfig.Configs[i].ConfigName)
+			return nil
+		}
+	}
+	deploymentsLock.Unlock()
+	return fmt.Errorf("Unable to find existing deployment for MAC address [%s]", macAddress)
+}
+
+// keepInstalled - an installed server remains installed when its deployment is updated (or loaded again after a
+// restart), it is only re-provisioned through SetDeploymentInstalled, the caller must hold the deployments lock
+func keepInstalled(configs []DeploymentConfig) {
+	for i := range configs {
+		if configs[i].Installed {
+			setInstalled(configs[i].MAC, true)
+		} else if installedDeployments[strings.ToLower(configs[i].MAC)] {
+			configs[i].Installed = true
+		}
+	}
+}
+
 // UpdateGlobalDeploymentConfig - This allows updating of the global configuration independently
 func UpdateGlobalDeploymentConfig(rawDeployment []byte) error {
 	var globalDeploymentConfig HostConfig
@@ -327,6 +612,8 @@ func UpdateGlobalDeploymentConfig(rawDeployment []byte) error {
 		return fmt.Errorf("Unable to parse deployment configuration")
 	}
 	// Update the deployments with the new configuration
+	deploymentsLock.Lock()
+	defer deploymentsLock.Unlock()
 	Deployments.GlobalServerConfig = globalDeploymentConfig
 	return nil
 }
@@ -339,6 +626,9 @@ func FindDeploymentConfigFromMac(mac string) string {
 	// 	return "anyboot"
 	// }
 
+	deploymentsLock.RLock()
+	defer deploymentsLock.RUnlock()
+
 	if len(Deployments.Configs) == 0 {
 		// No configurations have been loaded
 		log.Warnln("Attempted to perform Mac Address lookup, however no configurations have been loaded")
@@ -352,3 +642,42 @@ func FindDeploymentConfigFromMac(mac string) string {
 	}
 	return DefaultBootType
 }
+
+// findReservedAddress - this will return the address configured in a deployment for a mac address, allowing the DHCP server to
+// hand out a static reservation instead of an address from the pool
+func findReservedAddress(mac string) net.IP {
+	deployment := findDeploymentFromMac(mac)
+	if deployment == nil || deployment.ConfigHost.IPAddress == "" {
+		return nil
+	}
+	ip, err := utils.ConvertIP(deployment.ConfigHost.IPAddress)
+	if err != nil {
+		log.Warnf("Deployment [%s] has an invalid address, falling back to the DHCP pool", mac)
+		return nil
+	}
+	return ip
+}
+
+// findDeploymentFromMac - this will return the deployment for a (lowercase) mac address, or nil if the mac address is unknown
+func findDeploymentFromMac(mac string) *DeploymentConfig {
+	deploymentsLock.RLock()
+	defer deploymentsLock.RUnlock()
+	for i := range Deployments.Configs {
+		if mac == strings.ToLower(Deployments.Configs[i].MAC) {
+			return &Deployments.Configs[i]
+		}
+	}
+	return nil
+}
+
+// isReservedAddress - this will determine if an address has been configured for a deployment, so that it isn't handed out from the pool
+func isReservedAddress(ip net.IP) bool {
+	deploymentsLock.RLock()
+	defer deploymentsLock.RUnlock()
+	for i := range Deployments.Configs {
+		if ip.Equal(net.ParseIP(Deployments.Configs[i].ConfigHost.IPAddress)) {
+			return true
+		}
+	}
+	return false
+}
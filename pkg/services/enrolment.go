@@ -0,0 +1,210 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"plunder-app/plunder/pkg/utils"
+
+	dhcp "github.com/krolaw/dhcp4"
+	log "github.com/sirupsen/logrus"
+)
+
+// enrolmentMaxHosts bounds the search for a free hostname/address
+const enrolmentMaxHosts = 65536
+
+// enrolServer will look through the enrolment rules for the first one that matches a discovered server, and then create
+// a deployment for it
+func (h *DHCPSettings) enrolServer(mac string, options dhcp.Options) {
+	for i := range Controller.EnrolmentRules {
+		rule := &Controller.EnrolmentRules[i]
+		if !rule.matches(mac, options) {
+			continue
+		}
+
+		deployment, err := rule.newDeployment(mac, h)
+		if err != nil {
+			log.Warnf("Mac address [%s] matched enrolment rule [%s], however %v", mac, rule.RuleName, err)
+			return
+		}
+
+		b, err := json.Marshal(deployment)
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+
+		err = AddDeployment(b)
+		if err != nil {
+			log.Errorf("Mac address [%s] matched enrolment rule [%s], however the deployment couldn't be added [%v]", mac, rule.RuleName, err)
+			return
+		}
+		log.Infof("Mac address [%s] has been enrolled by rule [%s] with hostname [%s] and address [%s]", mac, rule.RuleName, deployment.ConfigHost.ServerName, deployment.ConfigHost.IPAddress)
+		return
+	}
+}
+
+// matches will determine if a discovered server meets all of the criteria of a rule
+func (r *EnrolmentRule) matches(mac string, options dhcp.Options) bool {
+	if r.MACPrefix != "" && !strings.HasPrefix(mac, strings.ToLower(strings.Replace(r.MACPrefix, "-", ":", -1))) {
+		return false
+	}
+	if r.VendorClass != "" && !strings.HasPrefix(string(options[dhcp.OptionVendorClassIdentifier]), r.VendorClass) {
+		return false
+	}
+	if r.UserClass != "" && string(options[dhcp.OptionUserClass]) != r.UserClass {
+		return false
+	}
+	if r.ClientArch != nil && int(clientArchitecture(options)) != *r.ClientArch {
+		return false
+	}
+	if len(r.SystemUUIDs) != 0 {
+		uuid := clientUUID(options)
+		if uuid == "" {
+			return false
+		}
+		for i := range r.SystemUUIDs {
+			if strings.EqualFold(r.SystemUUIDs[i], uuid) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// newDeployment will create a deployment from a rule, the hostname and address are each the first ones from the rule
+// patterns that aren't already in use by another deployment (or leased to another server)
+func (r *EnrolmentRule) newDeployment(mac string, h *DHCPSettings) (*DeploymentConfig, error) {
+	if r.MaxHosts != 0 {
+		// The deployments are counted by the name of the rule that created them
+		if r.RuleName == "" {
+			return nil, fmt.Errorf("the rule needs a ruleName to limit it to [%d] hosts", r.MaxHosts)
+		}
+		if r.enrolled() >= r.MaxHosts {
+			return nil, fmt.Errorf("all [%d] hosts for this rule have been enrolled", r.MaxHosts)
+		}
+	}
+
+	deployment := &DeploymentConfig{
+		MAC:           mac,
+		ConfigName:    r.ConfigName,
+		ConfigHost:    r.ConfigHost,
+		EnrolmentRule: r.RuleName,
+	}
+
+	if r.HostnamePattern != "" {
+		hostname, err := r.nextHostname()
+		if err != nil {
+			return nil, err
+		}
+		deployment.ConfigHost.ServerName = hostname
+	}
+
+	if r.AddressStart != "" {
+		start, err := utils.ConvertIP(r.AddressStart)
+		if err != nil {
+			return nil, err
+		}
+		ip, err := h.nextAddress(start, mac)
+		if err != nil {
+			return nil, err
+		}
+		deployment.ConfigHost.IPAddress = ip.String()
+	}
+	return deployment, nil
+}
+
+// validate will ensure that the hostnames can be generated from the rule, the hostname pattern needs a single integer
+// verb for the index
+func (r *EnrolmentRule) validate() error {
+	if r.HostnamePattern == "" {
+		return nil
+	}
+	// A pattern without a verb (or with a verb for something other than the index) leaves an error in the hostname,
+	// and one that doesn't use the index would generate the same hostname for every server
+	first := fmt.Sprintf(r.HostnamePattern, r.IndexStart)
+	if strings.Contains(first, "%!") || first == fmt.Sprintf(r.HostnamePattern, r.IndexStart+1) {
+		return fmt.Errorf("the hostnamePattern [%s] needs a single integer verb for the index e.g. worker-%%02d", r.HostnamePattern)
+	}
+	return nil
+}
+
+// validateEnrolmentRules will reject any of the enrolment rules that can't be used to create a deployment
+func (c *BootController) validateEnrolmentRules() error {
+	var rules []EnrolmentRule
+	var rejected []string
+	for i := range c.EnrolmentRules {
+		if err := c.EnrolmentRules[i].validate(); err != nil {
+			rejected = append(rejected, fmt.Sprintf("[%s] %v", c.EnrolmentRules[i].RuleName, err))
+			continue
+		}
+		rules = append(rules, c.EnrolmentRules[i])
+	}
+	c.EnrolmentRules = rules
+
+	if len(rejected) != 0 {
+		return fmt.Errorf("Rejected enrolment rules %s", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// enrolled will return the number of deployments that have been created by a rule
+func (r *EnrolmentRule) enrolled() int {
+	deploymentsLock.RLock()
+	defer deploymentsLock.RUnlock()
+	var count int
+	for i := range Deployments.Configs {
+		if Deployments.Configs[i].EnrolmentRule == r.RuleName {
+			count++
+		}
+	}
+	return count
+}
+
+// nextHostname will return the first hostname from the rule pattern that isn't in use by a deployment
+func (r *EnrolmentRule) nextHostname() (string, error) {
+	for n := 0; n < enrolmentMaxHosts; n++ {
+		hostname := fmt.Sprintf(r.HostnamePattern, r.IndexStart+n)
+		if !isHostnameInUse(hostname) {
+			return hostname, nil
+		}
+	}
+	return "", fmt.Errorf("no free hostname could be found for the pattern [%s]", r.HostnamePattern)
+}
+
+// nextAddress will return the first address from the start that isn't in use by a deployment or leased to another server
+func (h *DHCPSettings) nextAddress(start net.IP, mac string) (net.IP, error) {
+	for n := 0; n < enrolmentMaxHosts; n++ {
+		ip := dhcp.IPAdd(start, n)
+		if !isReservedAddress(ip) && !h.isLeasedAddress(ip, mac) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no free address could be found from [%s]", start.String())
+}
+
+// isLeasedAddress will determine if an address in one of the DHCP pools has an unexpired lease for another server
+func (h *DHCPSettings) isLeasedAddress(ip net.IP, mac string) bool {
+	scope, leaseNum := h.findScopeForAddress(ip)
+	if scope == nil {
+		return false
+	}
+	lease, ok := scope.Leases[leaseNum]
+	return ok && lease.MAC != mac && lease.Expiry.After(time.Now())
+}
+
+// isHostnameInUse - this will determine if a hostname has already been configured for a deployment
+func isHostnameInUse(hostname string) bool {
+	deploymentsLock.RLock()
+	defer deploymentsLock.RUnlock()
+	for i := range Deployments.Configs {
+		if strings.EqualFold(hostname, Deployments.Configs[i].ConfigHost.ServerName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"plunder-app/plunder/pkg/apiserver"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bootEventManager is the name of the notification manager that all boot events are published to
+const bootEventManager = "bootEvents"
+
+// The types of events that are published as a server is provisioned
+const (
+	EventDHCPDiscover           = "dhcp-discover"
+	EventDHCPOffer              = "dhcp-offer"
+	EventDHCPAck                = "dhcp-ack"
+	EventDHCPRelease            = "dhcp-release"
+	EventIPXEFetched            = "ipxe-fetched"
+	EventInstallerConfigFetched = "installer-config-fetched"
+	EventInstalling             = "installing"
+	EventInstalled              = "installed"
+	EventFailed                 = "failed"
+	EventReprovisioned          = "reprovisioned"
+	EventDeploymentAdded        = "deployment-added"
+	EventDeploymentUpdated      = "deployment-updated"
+	EventDeploymentDeleted      = "deployment-deleted"
+)
+
+// BootEvent is published to subscribers of the boot event stream
+type BootEvent struct {
+	Type       string    `json:"type"`
+	MAC        string    `json:"mac,omitempty"`
+	IP         string    `json:"address,omitempty"`
+	ConfigName string    `json:"configName,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// publishEvent will send an event to the notification manager, subscribers can receive the events for a specific
+// server (the mac address with dashes e.g. /events/00-11-22-33-44-55) or for all servers (/events/all). Events for
+// a deployment will also update its provisioning state.
+func publishEvent(eventType, mac, ip, configName string) {
+	if state, ok := eventStates[eventType]; ok && mac != "" {
+		// Servers without a deployment have no state
+		setDeploymentState(mac, ip, state)
+	}
+
+	event := BootEvent{
+		Type:       eventType,
+		MAC:        mac,
+		IP:         ip,
+		ConfigName: configName,
+		Timestamp:  time.Now(),
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+
+	err = apiserver.NotifyManager(bootEventManager, apiserver.Notification{
+		ID:      strings.Replace(mac, ":", "-", -1),
+		RawData: b,
+	})
+	if err != nil {
+		// The API server may not have been started yet
+		log.Debugf("%v", err)
+	}
+}
+
+// publishRequestEvent will publish an event when a server retrieves its iPXE script or installer configuration
+func publishRequestEvent(r *http.Request) {
+	filename := path.Base(r.URL.Path)
+	extension := path.Ext(filename)
+	name := strings.TrimSuffix(filename, extension)
+
+	var eventType string
+	switch {
+	case cloudInitFiles[filename], windowsFiles[filename]:
+		// The cloud-init and WinPE files are in a directory named after the mac address
+		eventType = EventInstallerConfigFetched
+		name = path.Base(path.Dir(r.URL.Path))
+	case extension == ".ipxe":
+		eventType = EventIPXEFetched
+	case extension == ".cfg", extension == ".ks", extension == ".bty", extension == ".ign", extension == ".xml":
+		eventType = EventInstallerConfigFetched
+	default:
+		return
+	}
+
+	// Files that are specific to a server are named after its mac address, anything else is a generic boot type
+	var mac, configName string
+	hwAddr, err := net.ParseMAC(name)
+	if err == nil {
+		mac = hwAddr.String()
+		if deployment := findDeploymentFromMac(mac); deployment != nil {
+			configName = deployment.ConfigName
+		}
+	} else {
+		configName = name
+	}
+
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	publishEvent(eventType, mac, address, configName)
+}
@@ -47,6 +47,59 @@ func RegisterToAPIServer() {
 		http.MethodDelete,
 		deleteBootConfig)
 
+	// ------------------------------------------------
+	//    ISO catalog API registration
+	// ------------------------------------------------
+
+	apiserver.AddDynamicEndpoint("/isos",
+		"/isos",
+		"Allows the retrieving of the ISOs in the ISO directory",
+		"isos",
+		http.MethodGet,
+		getISOs)
+
+	apiserver.AddDynamicEndpoint("/iso/{id}",
+		"/iso",
+		"Allows the retrieval of specific information about an ISO",
+		"iso",
+		http.MethodGet,
+		getISO)
+
+	apiserver.AddDynamicEndpoint("/iso/{id}",
+		"/iso",
+		"Allows the uploading of an ISO, the upload is verified if a ?sha256= checksum is specified",
+		"iso",
+		http.MethodPost,
+		postISO)
+
+	apiserver.AddDynamicEndpoint("/iso/{id}",
+		"/iso",
+		"Allows the deletion of an ISO that isn't in use by a boot configuration",
+		"iso",
+		http.MethodDelete,
+		deleteISO)
+
+	apiserver.AddDynamicEndpoint("/iso/{id}/files",
+		"/iso/files",
+		"Allows the browsing of the files in an ISO, using the ?path= and ?recursive=true options",
+		"isoFiles",
+		http.MethodGet,
+		getISOFiles)
+
+	apiserver.AddDynamicEndpoint("/iso/{id}/bootconfig",
+		"/iso/bootconfig",
+		"Generates a boot configuration for an ISO, using the ?prefix= and ?name= options",
+		"isoBootConfig",
+		http.MethodGet,
+		getISOBootConfig)
+
+	// ------------------------------------------------
+	//    Boot event streaming registration
+	// ------------------------------------------------
+
+	// Events are streamed for a specific mac address (with dashes) or for "all" servers
+	apiserver.RegisterNotificationManager(bootEventManager, "/events/{id}")
+
 	// ------------------------------------------------
 	//    DHCP configuration API registration
 	// ------------------------------------------------
@@ -76,6 +129,13 @@ func RegisterToAPIServer() {
 		http.MethodPost,
 		postDeployments)
 
+	apiserver.AddDynamicEndpoint("/deployments/state",
+		"/deployments/state",
+		"Allows the retrieving of the provisioning state of all Plunder Server deployments",
+		"deploymentsState",
+		http.MethodGet,
+		getDeploymentsState)
+
 	apiserver.AddDynamicEndpoint("/deployment",
 		"/deployment",
 		"Allows the creation of a specific Plunder deployment",
@@ -111,6 +171,13 @@ func RegisterToAPIServer() {
 		http.MethodDelete,
 		deleteDeployment)
 
+	apiserver.AddDynamicEndpoint("/deployment/{id}/reprovision",
+		"/deployment",
+		"Allows an installed Plunder Server deployment to be provisioned again",
+		"deploymentReprovision",
+		http.MethodPost,
+		reprovisionDeployment)
+
 	apiserver.AddDynamicEndpoint("/deployment/mac/{id}",
 		"/deployment/mac",
 		"Allows the deletion of a Plunder Server deployment based upon its MAC address",
@@ -233,7 +300,9 @@ func deleteBootConfig(w http.ResponseWriter, r *http.Request) {
 func getDeployments(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var rsp apiserver.Response
+	deploymentsLock.RLock()
 	jsonData, err := json.Marshal(Deployments)
+	deploymentsLock.RUnlock()
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		rsp.Warning = "Error retrieving deployment Configuration"
@@ -244,6 +313,27 @@ func getDeployments(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(rsp)
 }
 
+// Retrieve the provisioning state of all plunder deployments
+func getDeploymentsState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var rsp apiserver.Response
+
+	deploymentsLock.RLock()
+	deployments := make([]DeploymentConfig, len(Deployments.Configs))
+	for i := range Deployments.Configs {
+		deployments[i] = deploymentWithState(&Deployments.Configs[i])
+	}
+	deploymentsLock.RUnlock()
+	jsonData, err := json.Marshal(deployments)
+	if err != nil {
+		rsp.Warning = "Error retrieving deployment state"
+		rsp.Error = err.Error()
+	} else {
+		rsp.Payload = jsonData
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
 // Apply the plunder global deployment configuration
 
 func postDeployments(w http.ResponseWriter, r *http.Request) {
@@ -274,7 +364,7 @@ func getSpecificDeployment(w http.ResponseWriter, r *http.Request) {
 	deployment := GetDeployment(mac)
 
 	if deployment != nil {
-		jsonData, err := json.Marshal(deployment)
+		jsonData, err := json.Marshal(deploymentWithState(deployment))
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			rsp.Warning = "Error retrieving deployment Configuration"
@@ -372,6 +462,23 @@ func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Re-provision an installed plunder deployment
+func reprovisionDeployment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Find the deployment ID
+	id := mux.Vars(r)["id"]
+	var rsp apiserver.Response
+
+	// We need to revert the mac address back to the correct format (dashes back to colons)
+	err := SetDeploymentInstalled(strings.Replace(id, "-", ":", -1), false)
+	if err != nil {
+		rsp.Warning = "Error updating Deployment Configuration"
+		rsp.Error = err.Error()
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
 // Retrieve a specific plunder deployment configuration
 func deleteDeploymentMac(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -450,3 +557,98 @@ func getDHCP(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(rsp)
 }
+
+func getISOs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var rsp apiserver.Response
+
+	isos, err := ListISOs()
+	if err == nil {
+		rsp.Payload, err = json.Marshal(isos)
+	}
+	if err != nil {
+		rsp.Warning = "Error retrieving ISOs"
+		rsp.Error = err.Error()
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+func getISO(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var rsp apiserver.Response
+
+	iso, err := GetISO(mux.Vars(r)["id"])
+	if err == nil {
+		rsp.Payload, err = json.Marshal(iso)
+	}
+	if err != nil {
+		rsp.Warning = "Error retrieving ISO"
+		rsp.Error = err.Error()
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+// Upload an ISO, the body of the request is streamed directly to disk
+func postISO(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var rsp apiserver.Response
+
+	iso, err := UploadISO(mux.Vars(r)["id"], r.Body, r.URL.Query().Get("sha256"))
+	if err == nil {
+		rsp.Payload, err = json.Marshal(iso)
+	}
+	if err != nil {
+		rsp.Warning = "Error uploading ISO"
+		rsp.Error = err.Error()
+	} else {
+		rsp.Success = fmt.Sprintf("ISO [%s] uploaded", iso.Name)
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+func deleteISO(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var rsp apiserver.Response
+
+	err := DeleteISO(mux.Vars(r)["id"])
+	if err != nil {
+		rsp.Warning = "Error deleting ISO"
+		rsp.Error = err.Error()
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+func getISOFiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var rsp apiserver.Response
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+	files, err := BrowseISO(mux.Vars(r)["id"], r.URL.Query().Get("path"), recursive)
+	if err == nil {
+		rsp.Payload, err = json.Marshal(files)
+	}
+	if err != nil {
+		rsp.Warning = "Error browsing ISO"
+		rsp.Error = err.Error()
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+func getISOBootConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var rsp apiserver.Response
+
+	isoPath, err := isoCatalogPath(mux.Vars(r)["id"])
+	if err == nil {
+		var bc *BootConfig
+		bc, err = GenerateBootConfig(isoPath, r.URL.Query().Get("prefix"), r.URL.Query().Get("name"), HttpAddress)
+		if err == nil {
+			rsp.Payload, err = json.Marshal(bc)
+		}
+	}
+	if err != nil {
+		rsp.Warning = "Error generating boot configuration"
+		rsp.Error = err.Error()
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"plunder-app/plunder/pkg/utils"
+)
+
+// IPXETemplateData - is passed to a user supplied iPXE template when it is rendered
+type IPXETemplateData struct {
+	MAC         string // MAC address of the host e.g. 00:11:22:33:44:55 (blank for the boot type scripts e.g. /preseed.ipxe)
+	DashMAC     string // MAC address as it appears in URLs e.g. 00-11-22-33-44-55
+	HTTPAddress string // Address of the plunder HTTP server
+	TFTPAddress string // Address of the plunder TFTP server
+
+	Boot BootConfig // The boot configuration that the script is for
+	Host HostConfig // The configuration of the host (empty for the boot type scripts)
+
+	Header  string // The standard plunder iPXE header (begins with #!ipxe)
+	Default string // The built-in script for the configType
+}
+
+// ipxeTemplate returns the iPXE template for a boot configuration, it is blank if no template is configured
+func (b *BootConfig) ipxeTemplate() (string, error) {
+	if b.IPXETemplate != "" && b.IPXETemplatePath != "" {
+		return "", fmt.Errorf("Boot Config [%s] has both an ipxeTemplate and an ipxeTemplatePath", b.ConfigName)
+	}
+	if b.IPXETemplatePath != "" {
+		t, err := ioutil.ReadFile(b.IPXETemplatePath)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read iPXE template [%s] -> %v", b.IPXETemplatePath, err)
+		}
+		return string(t), nil
+	}
+	return b.IPXETemplate, nil
+}
+
+// buildIPXE will render the iPXE template of a boot configuration for a host, if there is no template then the
+// built-in script is returned unchanged
+func (b *BootConfig) buildIPXE(mac string, host HostConfig, builtin string) (string, error) {
+	t, err := b.ipxeTemplate()
+	if err != nil {
+		return "", err
+	}
+	if t == "" {
+		return builtin, nil
+	}
+
+	data := IPXETemplateData{
+		MAC:         mac,
+		DashMAC:     strings.Replace(mac, ":", "-", -1),
+		HTTPAddress: HttpAddress,
+		Boot:        *b,
+		Host:        host,
+		Header:      utils.IPXEHeader(),
+		Default:     builtin,
+	}
+	if Controller.TFTPAddress != nil {
+		data.TFTPAddress = *Controller.TFTPAddress
+	}
+	return utils.IPXETemplate(b.ConfigName, t, data)
+}
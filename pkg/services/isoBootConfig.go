@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// prefixRegex matches the characters that are replaced when an ISO filename is turned into a prefix
+var prefixRegex = regexp.MustCompile(`[^a-z0-9._]+`)
+
+// ISOPrefixFromPath - creates an ISO prefix from the filename of an ISO
+func ISOPrefixFromPath(isoPath string) string {
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(isoPath), filepath.Ext(isoPath)))
+	return strings.Trim(prefixRegex.ReplaceAllString(name, "-"), "-")
+}
+
+// GenerateBootConfig - will inspect an ISO and create a boot configuration for the distribution that it contains, the
+// httpAddress is the address of the plunder HTTP server that is used to build the suggested cmdline
+func GenerateBootConfig(isoPath, isoPrefix, configName, httpAddress string) (*BootConfig, error) {
+	image, err := indexISO(isoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer image.file.Close()
+
+	if isoPrefix == "" {
+		isoPrefix = ISOPrefixFromPath(isoPath)
+	}
+	if configName == "" {
+		configName = isoPrefix
+	}
+
+	distro, version := image.detectDistribution()
+	if distro == "" {
+		return nil, fmt.Errorf("Unable to detect the distribution of ISO [%s]", isoPath)
+	}
+	log.Infof("Detected [%s] version [%s] in ISO [%s]", distro, version, isoPath)
+
+	absPath, err := filepath.Abs(isoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := &BootConfig{
+		ConfigName: configName,
+		ISOPath:    absPath,
+		ISOPrefix:  isoPrefix,
+	}
+
+	// isoURL is the URL for the root of the ISO contents
+	isoURL := fmt.Sprintf("http://%s/%s", httpAddress, isoPrefix)
+
+	switch distro {
+	case distroUbuntu, distroDebian:
+		switch {
+		case image.firstFile("/install/netboot/ubuntu-installer/amd64/linux") != "":
+			// The debian-installer (netboot) from older Ubuntu server ISOs
+			bc.ConfigType = "preseed"
+			bc.Kernel = image.firstFile("/install/netboot/ubuntu-installer/amd64/linux")
+			bc.Initrd = image.firstFile("/install/netboot/ubuntu-installer/amd64/initrd.gz")
+		case image.firstFile("/install.amd/vmlinuz") != "":
+			// The debian-installer from Debian ISOs
+			bc.ConfigType = "preseed"
+			bc.Kernel = image.firstFile("/install.amd/vmlinuz")
+			bc.Initrd = image.firstFile("/install.amd/initrd.gz")
+		case image.firstFile("/casper/vmlinuz", "/casper/vmlinuz.efi") != "":
+			// The live server (subiquity) installer, which downloads the ISO and reads an autoinstall configuration
+			bc.ConfigType = "autoinstall"
+			bc.Kernel = image.firstFile("/casper/vmlinuz", "/casper/vmlinuz.efi")
+			bc.Initrd = image.firstFile("/casper/initrd", "/casper/initrd.gz", "/casper/initrd.lz")
+		}
+
+	case distroRHEL, distroCentOS, distroFedora, distroRocky, distroAlma, distroOracle:
+		bc.ConfigType = "kickstart"
+		bc.Kernel = image.firstFile("/images/pxeboot/vmlinuz")
+		bc.Initrd = image.firstFile("/images/pxeboot/initrd.img")
+		bc.Cmdline = fmt.Sprintf("inst.repo=%s/", isoURL)
+
+	case distroCoreOS:
+		// The live system installs to disk, and is passed the Ignition configuration for the server
+		bc.ConfigType = "ignition"
+		bc.Kernel = image.firstFile("/images/pxeboot/vmlinuz")
+		bc.Initrd = image.firstFile("/images/pxeboot/initrd.img")
+		bc.Cmdline = fmt.Sprintf("coreos.live.rootfs_url=%s/images/pxeboot/rootfs.img ignition.firstboot ignition.platform.id=metal", isoURL)
+
+	case distroESXi:
+		// The ESXi bootloader loads the kernel and modules from the boot.cfg
+		bc.ConfigType = "vsphere"
+		bc.Kernel = image.firstFile("/mboot.c32", "/efi/boot/bootx64.efi")
+
+	case distroWindows:
+		// wimboot isn't part of the ISO, it is downloaded with "plunder get" and served from the HTTP root
+		bc.ConfigType = "windows"
+		bc.Kernel = "wimboot"
+
+	case distroSUSE, distroOpenSUSE:
+		// The installation source (install=) is the ISO prefix, unless the server has a repository
+		bc.ConfigType = "autoyast"
+		bc.Kernel = image.firstFile("/boot/x86_64/loader/linux")
+		bc.Initrd = image.firstFile("/boot/x86_64/loader/initrd")
+	}
+
+	if bc.Kernel == "" {
+		return nil, fmt.Errorf("Unable to find a kernel for [%s] version [%s] in ISO [%s]", distro, version, isoPath)
+	}
+
+	// Kernel and initrd are served from the ISO prefix
+	if bc.ConfigType != "windows" {
+		bc.Kernel = isoPrefix + bc.Kernel
+	}
+	if bc.Initrd != "" {
+		bc.Initrd = isoPrefix + bc.Initrd
+	}
+	return bc, nil
+}
+
+// firstFile returns the first of the paths that exists in the ISO, or blank if none of them exist
+func (image *isoImage) firstFile(paths ...string) string {
+	for _, p := range paths {
+		if f, ok := image.files[p]; ok && !f.dir {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ISOCatalogEntry - describes an ISO that is held in the ISO directory
+type ISOCatalogEntry struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Modified     time.Time `json:"modified"`
+	SHA256       string    `json:"sha256"`
+	VolumeLabel  string    `json:"volumeLabel,omitempty"`
+	Distribution string    `json:"distribution,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	BootConfigs  []string  `json:"bootConfigs,omitempty"` // The boot configurations that use this ISO
+}
+
+// ISOFileEntry - describes a file or directory within an ISO
+type ISOFileEntry struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Directory bool      `json:"directory,omitempty"`
+	Modified  time.Time `json:"modified"`
+}
+
+// isoChecksumExtension is appended to the name of an ISO to store its checksum (in the sha256sum format)
+const isoChecksumExtension = ".sha256"
+
+// isoChecksumPending is the checksum of an ISO whilst it is being calculated
+const isoChecksumPending = "pending"
+
+// isoCatalog caches the details of ISOs, they are refreshed when an ISO changes. isoIndexes caches the directory tree
+// of each ISO (with the ISO closed) so that it can be browsed, and isoUploads holds the names of ISOs being uploaded.
+var isoCatalog = map[string]*ISOCatalogEntry{}
+var isoIndexes = map[string]*isoImage{}
+var isoUploads = map[string]bool{}
+var isoCatalogLock sync.Mutex
+
+// isoChecksums holds the ISOs whose checksum is being calculated
+var isoChecksums = map[string]bool{}
+var isoChecksumsLock sync.Mutex
+
+// isoDirectory returns the directory that holds the ISO catalog
+func isoDirectory() (string, error) {
+	if Controller.ISODirectory == nil || *Controller.ISODirectory == "" {
+		return "", fmt.Errorf("No ISO directory has been configured")
+	}
+	return *Controller.ISODirectory, nil
+}
+
+// isoCatalogPath will validate the name of an ISO and return its path within the ISO directory
+func isoCatalogPath(name string) (string, error) {
+	dir, err := isoDirectory()
+	if err != nil {
+		return "", err
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("Invalid ISO name [%s]", name)
+	}
+	if !strings.EqualFold(filepath.Ext(name), ".iso") {
+		return "", fmt.Errorf("ISO name [%s] must have the extension .iso", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// ListISOs - returns the details of all of the ISOs in the ISO directory
+func ListISOs() ([]ISOCatalogEntry, error) {
+	dir, err := isoDirectory()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read ISO directory [%s] -> %v", dir, err)
+	}
+
+	entries := []ISOCatalogEntry{}
+	for _, info := range files {
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(info.Name()), ".iso") {
+			continue
+		}
+		entry, err := catalogEntry(filepath.Join(dir, info.Name()), info)
+		if err != nil {
+			log.Warnf("Unable to catalog ISO [%s] -> %v", info.Name(), err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetISO - returns the details of a single ISO from the ISO directory
+func GetISO(name string) (*ISOCatalogEntry, error) {
+	isoPath, err := isoCatalogPath(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(isoPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find ISO [%s]", name)
+	}
+	entry, err := catalogEntry(isoPath, info)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// catalogEntry returns a copy of the (cached) details of an ISO, the boot configuration references are always
+// refreshed. The ISO is indexed without holding the lock, and a missing checksum is calculated in the background.
+func catalogEntry(isoPath string, info os.FileInfo) (ISOCatalogEntry, error) {
+	isoCatalogLock.Lock()
+	entry, ok := isoCatalog[isoPath]
+	isoCatalogLock.Unlock()
+
+	if !ok || entry.Size != info.Size() || !entry.Modified.Equal(info.ModTime()) {
+		image, err := indexISO(isoPath)
+		if err != nil {
+			return ISOCatalogEntry{}, err
+		}
+		entry = &ISOCatalogEntry{
+			Name:        filepath.Base(isoPath),
+			Path:        isoPath,
+			Size:        info.Size(),
+			Modified:    info.ModTime(),
+			VolumeLabel: image.volumeID,
+		}
+		entry.Distribution, entry.Version = image.detectDistribution()
+		image.file.Close()
+
+		entry.SHA256 = readISOChecksum(isoPath, info)
+		if entry.SHA256 == "" {
+			entry.SHA256 = isoChecksumPending
+			go calculateISOChecksum(isoPath, info)
+		}
+
+		isoCatalogLock.Lock()
+		isoCatalog[isoPath] = entry
+		isoIndexes[isoPath] = image
+		isoCatalogLock.Unlock()
+	}
+
+	isoCatalogLock.Lock()
+	defer isoCatalogLock.Unlock()
+	entry.BootConfigs = isoReferences(isoPath)
+	return *entry, nil
+}
+
+// readISOChecksum reads the checksum of an ISO from its checksum file, it is blank if the file doesn't exist (or is
+// older than the ISO)
+func readISOChecksum(isoPath string, info os.FileInfo) string {
+	checksumPath := isoPath + isoChecksumExtension
+	if checksumInfo, err := os.Stat(checksumPath); err == nil && !checksumInfo.ModTime().Before(info.ModTime()) {
+		b, err := ioutil.ReadFile(checksumPath)
+		if err == nil {
+			if fields := strings.Fields(string(b)); len(fields) != 0 && len(fields[0]) == sha256.Size*2 {
+				return strings.ToLower(fields[0])
+			}
+		}
+	}
+	return ""
+}
+
+// calculateISOChecksum calculates the checksum of an ISO and writes its checksum file, the catalog entry is then
+// updated (as long as the ISO hasn't changed in the meantime)
+func calculateISOChecksum(isoPath string, info os.FileInfo) {
+	isoChecksumsLock.Lock()
+	if isoChecksums[isoPath] {
+		isoChecksumsLock.Unlock()
+		return
+	}
+	isoChecksums[isoPath] = true
+	isoChecksumsLock.Unlock()
+
+	defer func() {
+		isoChecksumsLock.Lock()
+		delete(isoChecksums, isoPath)
+		isoChecksumsLock.Unlock()
+	}()
+
+	log.Infof("Calculating the checksum of ISO [%s]", isoPath)
+	f, err := os.Open(isoPath)
+	if err != nil {
+		log.Warnf("Unable to calculate the checksum of ISO [%s] -> %v", isoPath, err)
+		return
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		log.Warnf("Unable to calculate the checksum of ISO [%s] -> %v", isoPath, err)
+		return
+	}
+	checksum := hex.EncodeToString(h.Sum(nil))
+	writeISOChecksum(isoPath, checksum)
+
+	isoCatalogLock.Lock()
+	if entry, ok := isoCatalog[isoPath]; ok && entry.Size == info.Size() && entry.Modified.Equal(info.ModTime()) {
+		entry.SHA256 = checksum
+	}
+	isoCatalogLock.Unlock()
+}
+
+// writeISOChecksum writes the checksum file for an ISO, a failure isn't fatal as the checksum can be recalculated
+func writeISOChecksum(isoPath, checksum string) {
+	content := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(isoPath))
+	err := ioutil.WriteFile(isoPath+isoChecksumExtension, []byte(content), 0644)
+	if err != nil {
+		log.Warnf("Unable to write checksum for ISO [%s] -> %v", isoPath, err)
+	}
+}
+
+// isoReferences returns the names of the boot configurations that use an ISO
+func isoReferences(isoPath string) []string {
+	absPath, err := filepath.Abs(isoPath)
+	if err != nil {
+		return nil
+	}
+	var configs []string
+	for i := range Controller.BootConfigs {
+		if Controller.BootConfigs[i].ISOPath == "" {
+			continue
+		}
+		configPath, err := filepath.Abs(Controller.BootConfigs[i].ISOPath)
+		if err == nil && configPath == absPath {
+			configs = append(configs, Controller.BootConfigs[i].ConfigName)
+		}
+	}
+	return configs
+}
+
+// UploadISO - will stream an ISO into the ISO directory, if a checksum is specified then the ISO is only kept if it
+// matches. The ISO is written to a temporary file first so that a partial upload is never part of the catalog, the
+// name is reserved for the duration of the upload and the ISO is linked into place so an existing ISO is never replaced.
+func UploadISO(name string, r io.Reader, expectedChecksum string) (*ISOCatalogEntry, error) {
+	isoPath, err := isoCatalogPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	isoCatalogLock.Lock()
+	if _, err = os.Stat(isoPath); err == nil || isoUploads[isoPath] {
+		isoCatalogLock.Unlock()
+		return nil, fmt.Errorf("ISO [%s] already exists", name)
+	}
+	isoUploads[isoPath] = true
+	isoCatalogLock.Unlock()
+
+	defer func() {
+		isoCatalogLock.Lock()
+		delete(isoUploads, isoPath)
+		isoCatalogLock.Unlock()
+	}()
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(isoPath), ".plunder-upload")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create ISO [%s] -> %v", name, err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	h := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmpFile, h), r)
+	if err != nil {
+		tmpFile.Close()
+		return nil, fmt.Errorf("Unable to upload ISO [%s] -> %v", name, err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return nil, err
+	}
+
+	checksum := hex.EncodeToString(h.Sum(nil))
+	if expectedChecksum != "" && !strings.EqualFold(expectedChecksum, checksum) {
+		return nil, fmt.Errorf("Checksum of ISO [%s] is [%s], expected [%s]", name, checksum, expectedChecksum)
+	}
+
+	// Ensure that the upload is actually an ISO
+	image, err := indexISO(tmpFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	image.file.Close()
+
+	// Unlike a rename, a link fails if the ISO already exists
+	if err = os.Link(tmpFile.Name(), isoPath); err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("ISO [%s] already exists", name)
+		}
+		return nil, fmt.Errorf("Unable to create ISO [%s] -> %v", name, err)
+	}
+	writeISOChecksum(isoPath, checksum)
+	log.Infof("Uploaded ISO [%s], %d bytes with checksum [%s]", name, written, checksum)
+
+	return GetISO(name)
+}
+
+// DeleteISO - will remove an ISO from the ISO directory, as long as it isn't used by a boot configuration
+func DeleteISO(name string) error {
+	isoPath, err := isoCatalogPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(isoPath); err != nil {
+		return fmt.Errorf("Unable to find ISO [%s]", name)
+	}
+	if configs := isoReferences(isoPath); len(configs) != 0 {
+		return fmt.Errorf("ISO [%s] is in use by the boot configuration(s) [%s]", name, strings.Join(configs, ", "))
+	}
+
+	if err = os.Remove(isoPath); err != nil {
+		return fmt.Errorf("Unable to delete ISO [%s] -> %v", name, err)
+	}
+	os.Remove(isoPath + isoChecksumExtension)
+
+	isoCatalogLock.Lock()
+	delete(isoCatalog, isoPath)
+	delete(isoIndexes, isoPath)
+	isoCatalogLock.Unlock()
+	return nil
+}
+
+// BrowseISO - returns the files within a directory of an ISO, optionally including all sub-directories
+func BrowseISO(name, dirPath string, recursive bool) ([]ISOFileEntry, error) {
+	isoPath, err := isoCatalogPath(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(isoPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find ISO [%s]", name)
+	}
+
+	// The ISO is only indexed if it isn't already being served or cached by the catalog
+	image := servedISO(isoPath, info)
+	if image == nil {
+		if _, err = catalogEntry(isoPath, info); err != nil {
+			return nil, err
+		}
+		isoCatalogLock.Lock()
+		image = isoIndexes[isoPath]
+		isoCatalogLock.Unlock()
+		if image == nil {
+			return nil, fmt.Errorf("Unable to read ISO [%s]", name)
+		}
+	}
+
+	dirPath = path.Clean("/" + dirPath)
+	if dirPath != "/" {
+		if f, ok := image.files[dirPath]; !ok || !f.dir {
+			return nil, fmt.Errorf("Unable to find directory [%s] in ISO [%s]", dirPath, name)
+		}
+	}
+
+	entries := []ISOFileEntry{}
+	for filePath, f := range image.files {
+		parent := path.Dir(filePath)
+		if parent != dirPath && !(recursive && (dirPath == "/" || strings.HasPrefix(parent, dirPath+"/"))) {
+			continue
+		}
+		entries = append(entries, ISOFileEntry{
+			Path:      filePath,
+			Size:      f.size,
+			Directory: f.dir,
+			Modified:  f.modTime,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// servedISO returns the index of an ISO if it is being served by a boot configuration, and hasn't changed since
+func servedISO(isoPath string, info os.FileInfo) *isoImage {
+	absPath, err := filepath.Abs(isoPath)
+	if err != nil {
+		return nil
+	}
+
+	isoMapperLock.RLock()
+	defer isoMapperLock.RUnlock()
+	for _, image := range isoMapper {
+		if image == nil || image.size != info.Size() || !image.modTime.Equal(info.ModTime()) {
+			continue
+		}
+		if imagePath, err := filepath.Abs(image.path); err == nil && imagePath == absPath {
+			return image
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// isoMaxMetadataSize is the largest metadata file (e.g. .treeinfo) that will be read from an ISO
+const isoMaxMetadataSize = 1024 * 1024
+
+// The distributions that can be detected from the contents of an ISO
+const (
+	distroUbuntu   = "ubuntu"
+	distroDebian   = "debian"
+	distroRHEL     = "rhel"
+	distroCentOS   = "centos"
+	distroFedora   = "fedora"
+	distroRocky    = "rocky"
+	distroAlma     = "almalinux"
+	distroOracle   = "oraclelinux"
+	distroCoreOS   = "coreos"
+	distroESXi     = "esxi"
+	distroSUSE     = "sles"
+	distroOpenSUSE = "opensuse"
+	distroWindows  = "windows"
+)
+
+// versionRegex finds the first version number in a string
+var versionRegex = regexp.MustCompile(`[0-9]+(\.[0-9]+)*`)
+
+// servicePackRegex finds a SUSE service pack in a volume label
+var servicePackRegex = regexp.MustCompile(`(?i)-SP([0-9]+)`)
+
+// readFile will read a (small) file from an indexed ISO
+func (image *isoImage) readFile(filePath string) ([]byte, error) {
+	f, ok := image.files[filePath]
+	if !ok || f.dir {
+		return nil, fmt.Errorf("Unable to find file [%s]", filePath)
+	}
+	if f.size > isoMaxMetadataSize {
+		return nil, fmt.Errorf("File [%s] is too large [%d bytes]", filePath, f.size)
+	}
+	b := make([]byte, f.size)
+	_, err := image.reader(f).ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+// exists will determine if a path exists within an ISO
+func (image *isoImage) exists(filePath string) bool {
+	_, ok := image.files[filePath]
+	return ok
+}
+
+// detectDistribution will identify the distribution (and version) of an ISO from the files that it contains, the
+// distribution is blank if it can't be identified
+func (image *isoImage) detectDistribution() (string, string) {
+	volumeID := strings.ToLower(image.volumeID)
+
+	switch {
+	// CoreOS live images also contain a .treeinfo, so they're checked first
+	case image.exists("/coreos") || strings.HasPrefix(volumeID, "fedora-coreos") || strings.HasPrefix(volumeID, "rhcos"):
+		return distroCoreOS, versionRegex.FindString(image.volumeID)
+
+	case image.exists("/.treeinfo"):
+		return image.detectTreeInfo()
+
+	case image.exists("/.disk/info"):
+		b, err := image.readFile("/.disk/info")
+		if err != nil {
+			return "", ""
+		}
+		info := strings.ToLower(string(b))
+		switch {
+		case strings.HasPrefix(info, "ubuntu"):
+			return distroUbuntu, versionRegex.FindString(info)
+		case strings.HasPrefix(info, "debian"):
+			return distroDebian, versionRegex.FindString(info)
+		}
+
+	case image.exists("/boot.cfg") && (strings.HasPrefix(volumeID, "esxi") || image.exists("/efi/boot/boot.cfg")):
+		// e.g. ESXI-7.0U3G-20328353-STANDARD
+		return distroESXi, strings.SplitN(strings.TrimPrefix(image.volumeID, "ESXI-"), "-", 2)[0]
+
+	case image.exists("/sources/boot.wim"):
+		return distroWindows, versionRegex.FindString(image.volumeID)
+
+	case image.exists("/media.1/products") || image.exists("/content") || strings.HasPrefix(volumeID, "sle") || strings.HasPrefix(volumeID, "opensuse"):
+		// e.g. SLE-15-SP3-Full-x86_6412345 or openSUSE-Leap-15.3-DVD-x86_64
+		distro := distroSUSE
+		if strings.HasPrefix(volumeID, "opensuse") {
+			distro = distroOpenSUSE
+		}
+		version := versionRegex.FindString(image.volumeID)
+		if sp := servicePackRegex.FindStringSubmatch(image.volumeID); sp != nil {
+			version = fmt.Sprintf("%s.%s", version, sp[1])
+		}
+		return distro, version
+	}
+	return "", ""
+}
+
+// detectTreeInfo identifies a Red Hat family distribution from the .treeinfo file
+func (image *isoImage) detectTreeInfo() (string, string) {
+	b, err := image.readFile("/.treeinfo")
+	if err != nil {
+		return "", ""
+	}
+
+	// The [release] section is from the newer format, [general] is from the older format
+	values := map[string]string{}
+	var section string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && (section == "release" || section == "general") {
+			key := section + "." + strings.TrimSpace(kv[0])
+			values[key] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	name := values["release.name"]
+	if name == "" {
+		name = values["general.family"]
+	}
+	version := values["release.version"]
+	if version == "" {
+		version = values["general.version"]
+	}
+
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "centos"):
+		return distroCentOS, version
+	case strings.Contains(name, "fedora"):
+		return distroFedora, version
+	case strings.Contains(name, "rocky"):
+		return distroRocky, version
+	case strings.Contains(name, "alma"):
+		return distroAlma, version
+	case strings.Contains(name, "oracle"):
+		return distroOracle, version
+	}
+	return distroRHEL, version
+}
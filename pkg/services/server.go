@@ -36,36 +36,56 @@ func ParseControllerData(b []byte) error {
 			return fmt.Errorf("Unable to parse configuration as either yaml or json")
 		}
 	}
-	return nil
+	return Controller.validateEnrolmentRules()
 }
 
 // Parse will read through a new configuration and implement the configuration if possible
 func (b *BootConfig) Parse() error {
-	if isoMapper == nil {
-		// Ensure it is initialised before trying to use it
-		isoMapper = make(map[string]string)
+	// Ensure that an iPXE template can be rendered before it is used
+	if _, err := b.buildIPXE("", HostConfig{}, ""); err != nil {
+		return err
+	}
+
+	// Ensure that an installer template can be parsed and rendered before it is used
+	if _, err := b.installerTemplate(); err != nil {
+		return err
+	}
+
+	// The Windows boot files are read from the ISO
+	if b.ConfigType == "windows" && b.ISOPrefix == "" {
+		return fmt.Errorf("Boot Config [%s] of type [windows] requires an isoPrefix", b.ConfigName)
 	}
 
 	if b.ISOPrefix == "" || b.ISOPath == "" {
 		log.Debugf("No ISO is being parsed for configuration %s", b.ConfigName)
 	} else {
-		// Atempt to open the ISO and add it to the map for usage later
+		// Create the prefix
+		urlPrefix := fmt.Sprintf("/%s/", b.ISOPrefix)
+
+		isoMapperLock.RLock()
+		_, handlerExists := isoMapper[b.ISOPrefix]
+		isoMapperLock.RUnlock()
+
+		// Atempt to open and index the ISO and add it to the map for usage later
 		err := OpenISO(b.ISOPath, b.ISOPrefix)
 		if err != nil {
 			log.Errorf("Error parsing ISO [%v]", err)
 			return err
 		}
 
-		// Create the prefix
-		urlPrefix := fmt.Sprintf("/%s/", b.ISOPrefix)
+		// The boot.cfg of an ESXi ISO is rewritten for each deployment
+		if b.ConfigType == "vsphere" {
+			if _, err := readESXiBootCfg(b.ISOPrefix); err != nil {
+				return err
+			}
+		}
 
 		// Only create the handler if one doesn't exist
-		if _, ok := isoMapper[b.ISOPrefix]; !ok {
+		if !handlerExists {
 			log.Debugf("Adding handler %s", urlPrefix)
 			serveMux.HandleFunc(urlPrefix, isoReader)
-
-			// Add the iso path to the correct prefix
-			isoMapper[b.ISOPrefix] = b.ISOPath
+			// The entire ISO is also available e.g. /ubuntu.iso
+			serveMux.HandleFunc(fmt.Sprintf("/%s.iso", b.ISOPrefix), isoImageReader)
 		}
 
 		log.Debugf("Updating handler %s for config %s", urlPrefix, b.ConfigName)
@@ -117,10 +137,12 @@ func (c *BootController) DeleteBootControllerConfig(configName string) error {
 	for i := range c.BootConfigs {
 		if c.BootConfigs[i].ConfigName == configName {
 			// Remove the mapping to an ISO path
-			if isoMapper != nil {
-				// Ensure it is initialised before trying to remove boot config
-				isoMapper[c.BootConfigs[i].ISOPrefix] = ""
+			// (the handler still exists, so the prefix is kept)
+			isoMapperLock.Lock()
+			if _, ok := isoMapper[c.BootConfigs[i].ISOPrefix]; ok {
+				isoMapper[c.BootConfigs[i].ISOPrefix] = nil
 			}
+			isoMapperLock.Unlock()
 			c.BootConfigs = append(c.BootConfigs[:i], c.BootConfigs[i+1:]...)
 			return nil
 		}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"fmt"
-	"math/rand"
 	"net"
 	"strings"
 	"time"
@@ -13,21 +12,29 @@ import (
 
 // Lease defines a lease that is allocated to a client
 type Lease struct {
-	MAC    string    `json:"mac"`  // Client's Physical Address
-	Expiry time.Time `json:"time"` // When the lease expires
+	MAC    string    `json:"mac"`               // Client's Physical Address
+	IP     string    `json:"address,omitempty"` // Address allocated to the client
+	Expiry time.Time `json:"time"`              // When the lease expires
 }
 
 // DHCPSettings -
 type DHCPSettings struct {
-	IP      net.IP       // Server IP to use
-	Options dhcp.Options // Options to send to DHCP Clients
-	Start   net.IP       // Start of IP range to distribute
+	IP net.IP // Server IP to use
 
-	LeaseRange    int           // Number of IPs to distribute (starting from start)
 	LeaseDuration time.Duration // Lease period
 
-	Leases   map[int]Lease // Map to keep track of leases
-	UnLeased []Lease       // Map to keep track of unleased devices, and when they were seen
+	Scopes   []*DHCPScope // The local scope followed by any scopes that are reached through a DHCP relay
+	UnLeased []Lease      // Map to keep track of unleased devices, and when they were seen
+
+	LeaseFile string // Path to the lease database (blank disables persistence)
+
+	Proxy      bool   // Run as a proxyDHCP server, only PXE boot information is returned
+	NextServer net.IP // Server that PXE clients retrieve their bootloader from
+
+	// Bootloaders for the various client architectures
+	BIOSFileName  string // Legacy BIOS clients e.g. undionly.kpxe
+	EFIFileName   string // x86_64 UEFI clients e.g. ipxe.efi
+	ARM64FileName string // arm64 UEFI clients e.g. snp.efi
 }
 
 // Discover - Is the discovering of a DHCP server on the network and the typical result is an lease "offer"
@@ -36,57 +43,68 @@ type DHCPSettings struct {
 
 //ServeDHCP - Is the function that is called when ever plunder recieves DHCP packets.
 func (h *DHCPSettings) ServeDHCP(p dhcp.Packet, msgType dhcp.MessageType, options dhcp.Options) (d dhcp.Packet) {
+	// A proxyDHCP server doesn't allocate addresses, so requests are handled separately
+	if h.Proxy {
+		return h.serveProxyDHCP(p, msgType, options, false)
+	}
+
 	mac := strings.ToLower(p.CHAddr().String())
 	log.Debugf("DCHP Message Type: [%v] from MAC Address [%s]", msgType, mac)
 
+	// Unknown servers may be automatically enrolled if they match a rule
+	if msgType == dhcp.Discover && findDeploymentFromMac(mac) == nil {
+		h.enrolServer(mac, options)
+	}
+
+	// Find the scope (network) that this request has come from
+	scope := h.findScope(p)
+	if scope == nil {
+		log.Warnf("Mac address [%s] is on an unknown network (relay [%s]), ignoring", mac, p.GIAddr().String())
+		return nil
+	}
+
 	// Retrieve teh deployment type
 	deploymentType := FindDeploymentConfigFromMac(mac)
-	// Convert the : in the mac address to dashes to make life easier
-	dashMac := strings.Replace(mac, ":", "-", -1)
 
 	// These packets typicallty will be in one of a number of phases:
 	switch msgType {
 	case dhcp.Discover:
-
-		// Look for an existing license
-		free := -1
-		for i, v := range h.Leases { // Find previous lease
-			if v.MAC == mac {
-				free = i
-				goto reply
+		publishEvent(EventDHCPDiscover, mac, "", deploymentType)
+
+		// Hosts with a deployment are always offered the address from their configuration
+		ipLease := findReservedAddress(mac)
+		if ipLease == nil {
+			// Look for an existing lease
+			free := -1
+			for i, v := range scope.Leases { // Find previous lease
+				if v.MAC == mac {
+					free = i
+					break
+				}
 			}
-		}
 
-		// Look for a free lease
-		if free = h.freeLease(); free == -1 {
-			// No leases available
-			return
+			// Look for a free lease
+			if free == -1 {
+				if free = scope.freeLease(); free == -1 {
+					// No leases available
+					return
+				}
+			}
+			ipLease = dhcp.IPAdd(scope.Start, free)
 		}
-	reply:
-		//TODO - work out why this is here
-		h.Options[dhcp.OptionVendorClassIdentifier] = h.IP
 
 		// if DHCP option "OptionUserClass" is set to iPXE then we know that it's default booted to the correct bootloader
 		if string(options[dhcp.OptionUserClass]) == "iPXE" {
 			// This will ensure that the leasing table is kept updated for when a server was last seen
 			h.leaseHander(deploymentType, mac)
-
-			// TODO - This can be removed and left in the REQUEST section only
-
-			// if an entry doesnt exist then drop it to a default type, if not then it has its own specific
-			if httpPaths[fmt.Sprintf("%s.ipxe", dashMac)] == "" {
-				h.Options[dhcp.OptionBootFileName] = []byte("http://" + h.IP.String() + "/" + deploymentType + ".ipxe")
-			} else {
-				h.Options[dhcp.OptionBootFileName] = []byte("http://" + h.IP.String() + "/" + dashMac + ".ipxe")
-			}
-
 		}
 
-		ipLease := dhcp.IPAdd(h.Start, free)
-		log.Debugf("Allocated IP [%s] for [%s]", ipLease.String(), mac)
+		log.Debugf("Allocated IP [%s] for [%s] from scope [%s]", ipLease.String(), mac, scope.Name)
+
+		publishEvent(EventDHCPOffer, mac, ipLease.String(), deploymentType)
 
 		return dhcp.ReplyPacket(p, dhcp.Offer, h.IP, ipLease, h.LeaseDuration,
-			h.Options.SelectOrderOrAll(options[dhcp.OptionParameterRequestList]))
+			h.replyOptions(scope, options, mac, deploymentType))
 
 	case dhcp.Request:
 
@@ -99,47 +117,130 @@ func (h *DHCPSettings) ServeDHCP(p dhcp.Packet, msgType dhcp.MessageType, option
 		}
 
 		if len(reqIP) == 4 && !reqIP.Equal(net.IPv4zero) {
-			if leaseNum := dhcp.IPRange(h.Start, reqIP) - 1; leaseNum >= 0 && leaseNum < h.LeaseRange {
-				if l, exists := h.Leases[leaseNum]; !exists || l.MAC == p.CHAddr().String() {
+			// Hosts with a deployment can only be given the address from their configuration
+			if reserved := findReservedAddress(mac); reserved != nil {
+				if !reqIP.Equal(reserved) {
+					log.Debugf("Mac address [%s] requested [%s], however it is reserved [%s]", mac, reqIP.String(), reserved.String())
+					return dhcp.ReplyPacket(p, dhcp.NAK, h.IP, nil, 0, nil)
+				}
+
+				// Remove any pool lease that was allocated before this host had a deployment
+				h.releaseLease(mac)
+
+				// if DHCP option "OptionUserClass" is set to iPXE then we know that it's default booted to the correct bootloader
+				if string(options[dhcp.OptionUserClass]) == "iPXE" {
+					// Only Print out this notification if it's from the iPXE Boot loader
+					log.Infof("Mac address [%s] is assigned a [%s] deployment type with reserved address [%s]", mac, deploymentType, reserved.String())
+				}
+
+				publishEvent(EventDHCPAck, mac, reqIP.String(), deploymentType)
+
+				return dhcp.ReplyPacket(p, dhcp.ACK, h.IP, reqIP, h.LeaseDuration,
+					h.replyOptions(scope, options, mac, deploymentType))
+			}
+
+			// Addresses reserved for another deployment can't be requested from the pool
+			if leaseNum := dhcp.IPRange(scope.Start, reqIP) - 1; leaseNum >= 0 && leaseNum < scope.LeaseRange && !isReservedAddress(reqIP) {
+				if l, exists := scope.Leases[leaseNum]; !exists || l.MAC == p.CHAddr().String() {
 
 					// Specify the new lease
-					h.Leases[leaseNum] = Lease{
+					scope.Leases[leaseNum] = Lease{
 						MAC:    p.CHAddr().String(),
+						IP:     reqIP.String(),
 						Expiry: time.Now().Add(h.LeaseDuration),
 					}
 
+					// Update the lease database so this allocation survives a restart
+					if err := h.saveLeases(); err != nil {
+						log.Errorf("Unable to save DHCP leases [%v]", err)
+					}
+
 					// if DHCP option "OptionUserClass" is set to iPXE then we know that it's default booted to the correct bootloader
 					if string(options[dhcp.OptionUserClass]) == "iPXE" {
 						// Only Print out this notification if it's from the iPXE Boot loader
 						log.Infof("Mac address [%s] is assigned a [%s] deployment type", mac, deploymentType)
 					}
 
-					// if an entry doesnt exist then drop it to a default type, if not then it has its own specific
-					if httpPaths[fmt.Sprintf("/%s.ipxe", dashMac)] == "" {
-						h.Options[dhcp.OptionBootFileName] = []byte("http://" + h.IP.String() + "/" + deploymentType + ".ipxe")
-					} else {
-						h.Options[dhcp.OptionBootFileName] = []byte("http://" + h.IP.String() + "/" + dashMac + ".ipxe")
-					}
+					publishEvent(EventDHCPAck, mac, reqIP.String(), deploymentType)
 
 					return dhcp.ReplyPacket(p, dhcp.ACK, h.IP, reqIP, h.LeaseDuration,
-						h.Options.SelectOrderOrAll(options[dhcp.OptionParameterRequestList]))
+						h.replyOptions(scope, options, mac, deploymentType))
 				}
 			}
 		}
 		return dhcp.ReplyPacket(p, dhcp.NAK, h.IP, nil, 0, nil)
 
 	case dhcp.Release, dhcp.Decline:
-		for i, v := range h.Leases {
+		publishEvent(EventDHCPRelease, mac, p.CIAddr().String(), deploymentType)
+		h.releaseLease(mac)
+	}
+	return nil
+}
+
+// replyOptions will build the options for a reply from the scope options, a PXE client is pointed at the iPXE bootloader
+// for its architecture, once iPXE is running it is then pointed at either the hosts own iPXE script or the script for its deployment type
+func (h *DHCPSettings) replyOptions(scope *DHCPScope, options dhcp.Options, mac, deploymentType string) []dhcp.Option {
+	replyOptions := dhcp.Options{}
+	for code, value := range scope.Options {
+		replyOptions[code] = value
+	}
+
+	// Hosts with a deployment are given their hostname along with any options specific to them
+	if deployment := findDeploymentFromMac(mac); deployment != nil {
+		if deployment.ConfigHost.ServerName != "" {
+			replyOptions[dhcp.OptionHostName] = []byte(deployment.ConfigHost.ServerName)
+		}
+		err := applyOptions(replyOptions, deployment.DHCPOptions)
+		if err != nil {
+			log.Errorf("Mac address [%s] -> %v", mac, err)
+		}
+	}
+
+	// Convert the : in the mac address to dashes to make life easier
+	dashMac := strings.Replace(mac, ":", "-", -1)
+
+	//TODO - work out why this is here
+	replyOptions[dhcp.OptionVendorClassIdentifier] = h.IP
+
+	if string(options[dhcp.OptionUserClass]) == "iPXE" {
+		replyOptions[dhcp.OptionBootFileName] = []byte(h.bootFileURL(dashMac, deploymentType))
+	} else {
+		replyOptions[dhcp.OptionBootFileName] = []byte(h.bootloaderFileName(options))
+	}
+	return replyOptions.SelectOrderOrAll(options[dhcp.OptionParameterRequestList])
+}
+
+// bootFileURL will return the url of the iPXE script for a host
+func (h *DHCPSettings) bootFileURL(dashMac, deploymentType string) string {
+	// if an entry doesnt exist then drop it to a default type, if not then it has its own specific
+	deploymentsLock.RLock()
+	ipxe := httpPaths[fmt.Sprintf("/%s.ipxe", dashMac)]
+	deploymentsLock.RUnlock()
+	if ipxe == "" {
+		return "http://" + h.IP.String() + "/" + deploymentType + ".ipxe"
+	}
+	return "http://" + h.IP.String() + "/" + dashMac + ".ipxe"
+}
+
+// releaseLease will remove any pool lease held by a mac address and update the lease database
+func (h *DHCPSettings) releaseLease(mac string) {
+	for _, scope := range h.Scopes {
+		for i, v := range scope.Leases {
 			if v.MAC == mac {
 				log.Debugf("Releasing lease for [%s]", mac)
-				delete(h.Leases, i)
-				break
+				delete(scope.Leases, i)
+				if err := h.saveLeases(); err != nil {
+					log.Errorf("Unable to save DHCP leases [%v]", err)
+				}
+				return
 			}
 		}
 	}
-	return nil
 }
 
+// unLeasedSaveInterval limits how often the lease database is written when an unleased server is seen again
+const unLeasedSaveInterval = time.Minute
+
 // leaseHandler() will take care of adding and removing leases based upon use-case
 func (h *DHCPSettings) leaseHander(deploymentType, mac string) {
 	if deploymentType == "" || deploymentType == "autoBoot" || deploymentType == "reboot" {
@@ -150,11 +251,13 @@ func (h *DHCPSettings) leaseHander(deploymentType, mac string) {
 		}
 		// False by default
 		var macFound bool
+		save := true
 
 		// Look through array
 		for i := range h.UnLeased {
 			if mac == h.UnLeased[i].MAC {
-				h.UnLeased[i].Expiry = time.Now()
+				save = newUnleased.Expiry.Sub(h.UnLeased[i].Expiry) > unLeasedSaveInterval
+				h.UnLeased[i].Expiry = newUnleased.Expiry
 				// Found this entry
 				macFound = true
 			}
@@ -165,6 +268,13 @@ func (h *DHCPSettings) leaseHander(deploymentType, mac string) {
 			// Update the unleased map with this mac address being seen
 			h.UnLeased = append(h.UnLeased, newUnleased)
 		}
+
+		// The unleased servers are part of the lease database, so that they can still be found after a restart
+		if save {
+			if err := h.saveLeases(); err != nil {
+				log.Errorf("Unable to save DHCP leases [%v]", err)
+			}
+		}
 	}
 
 	// If this mac address has no deployment type for whatever reason, ensure a warning message is presented
@@ -173,24 +283,16 @@ func (h *DHCPSettings) leaseHander(deploymentType, mac string) {
 	}
 }
 
-func (h *DHCPSettings) freeLease() int {
-	now := time.Now()
-	b := rand.Intn(h.LeaseRange) // Try random first
-	for _, v := range [][]int{{b, h.LeaseRange}, {0, b}} {
-		for i := v[0]; i < v[1]; i++ {
-			if l, ok := h.Leases[i]; !ok || l.Expiry.Before(now) {
-				return i
-			}
-		}
-	}
-	return -1
-}
-
 // GetLeases - This will retrieve all of the allocated leases from the boot controller
 func (c *BootController) GetLeases() *[]Lease {
 	var l []Lease
-	for i := range c.handler.Leases {
-		l = append(l, c.handler.Leases[i])
+	if c.handler == nil {
+		return &l
+	}
+	for _, scope := range c.handler.Scopes {
+		for i := range scope.Leases {
+			l = append(l, scope.Leases[i])
+		}
 	}
 	return &l
 }
@@ -215,6 +317,10 @@ func (c *BootController) DelUnLeased(mac string) {
 	for i := range c.handler.UnLeased {
 		if mac == c.handler.UnLeased[i].MAC {
 			c.handler.UnLeased = append(c.handler.UnLeased[:i], c.handler.UnLeased[i+1:]...)
+			if err := c.handler.saveLeases(); err != nil {
+				log.Errorf("Unable to save DHCP leases [%v]", err)
+			}
+			return
 		}
 	}
 }
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	dhcp "github.com/krolaw/dhcp4"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client system architecture types (option 93) as defined in RFC 4578 and the IANA processor architecture registry
+const (
+	archBIOS     uint16 = 0  // Intel x86PC
+	archEFIx64   uint16 = 7  // EFI x86-64
+	archEFIBC    uint16 = 9  // EFI BC (typically reported by x86-64 firmware)
+	archEFIARM64 uint16 = 11 // ARM 64-bit UEFI
+)
+
+// optionClientMachineIdentifier is the client machine identifier (option 97) that PXE clients use to send their UUID
+const optionClientMachineIdentifier dhcp.OptionCode = 97
+
+// clientUUID will return the SMBIOS system UUID that a PXE client sends in option 97 (a type of 0 followed by the 16 byte
+// UUID), the first three fields are little endian so that it matches the UUID reported by the server (e.g. dmidecode)
+func clientUUID(options dhcp.Options) string {
+	id := options[optionClientMachineIdentifier]
+	if len(id) != 17 || id[0] != 0 {
+		return ""
+	}
+	u := id[1:]
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x", binary.LittleEndian.Uint32(u[0:4]), binary.LittleEndian.Uint16(u[4:6]),
+		binary.LittleEndian.Uint16(u[6:8]), u[8:10], u[10:16])
+}
+
+// clientArchitecture will determine the architecture of a PXE client, first from the client system architecture (option 93)
+// and then from the vendor class identifier (option 60) which takes the form PXEClient:Arch:xxxxx:UNDI:yyyzzz
+func clientArchitecture(options dhcp.Options) uint16 {
+	if arch, ok := options[dhcp.OptionClientArchitecture]; ok && len(arch) >= 2 {
+		return binary.BigEndian.Uint16(arch[:2])
+	}
+
+	vendorClass := strings.Split(string(options[dhcp.OptionVendorClassIdentifier]), ":")
+	for i := range vendorClass {
+		if vendorClass[i] == "Arch" && i+1 < len(vendorClass) {
+			arch, err := strconv.ParseUint(vendorClass[i+1], 10, 16)
+			if err == nil {
+				return uint16(arch)
+			}
+		}
+	}
+
+	// Nothing was advertised so assume a legacy BIOS client
+	return archBIOS
+}
+
+// bootloaderFileName will return the iPXE bootloader that matches the architecture of a PXE client
+func (h *DHCPSettings) bootloaderFileName(options dhcp.Options) string {
+	arch := clientArchitecture(options)
+	switch arch {
+	case archEFIx64, archEFIBC:
+		log.Debugf("Client architecture [%d] is x86_64 UEFI, using bootloader [%s]", arch, h.EFIFileName)
+		return h.EFIFileName
+	case archEFIARM64:
+		log.Debugf("Client architecture [%d] is arm64 UEFI, using bootloader [%s]", arch, h.ARM64FileName)
+		return h.ARM64FileName
+	case archBIOS:
+		return h.BIOSFileName
+	default:
+		log.Warnf("Client architecture [%d] is unsupported, falling back to bootloader [%s]", arch, h.BIOSFileName)
+		return h.BIOSFileName
+	}
+}
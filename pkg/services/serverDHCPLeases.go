@@ -0,0 +1,116 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// leaseDatabase is the on-disk representation of the DHCP server state, leases are stored by address
+// (rather than by their offset in the pool) so that a change to the start address doesn't corrupt them
+type leaseDatabase struct {
+	Leases   []Lease `json:"leases"`
+	UnLeased []Lease `json:"unleased"`
+}
+
+// loadLeases will read the lease database from disk and populate the DHCP settings with any leases that haven't expired
+func (h *DHCPSettings) loadLeases() error {
+	if h.LeaseFile == "" {
+		log.Debugf("No DHCP lease file specified, leases will not be persisted")
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(h.LeaseFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Infof("No existing DHCP lease database found at [%s]", h.LeaseFile)
+			return nil
+		}
+		return err
+	}
+
+	var db leaseDatabase
+	err = json.Unmarshal(b, &db)
+	if err != nil {
+		return fmt.Errorf("Unable to parse DHCP lease database [%s]\n %s", h.LeaseFile, err.Error())
+	}
+
+	now := time.Now()
+	var restored int
+	for i := range db.Leases {
+		// Throw away any leases that have expired whilst plunder wasn't running
+		if db.Leases[i].Expiry.Before(now) {
+			log.Debugf("Discarding expired lease for [%s]", db.Leases[i].MAC)
+			continue
+		}
+
+		ip := net.ParseIP(db.Leases[i].IP)
+		if ip == nil {
+			log.Warnf("Discarding lease for [%s] with invalid address [%s]", db.Leases[i].MAC, db.Leases[i].IP)
+			continue
+		}
+
+		// Ensure the lease still sits within one of the currently configured pools
+		scope, leaseNum := h.findScopeForAddress(ip)
+		if scope == nil {
+			log.Warnf("Discarding lease for [%s], address [%s] is outside of the DHCP range", db.Leases[i].MAC, db.Leases[i].IP)
+			continue
+		}
+		scope.Leases[leaseNum] = db.Leases[i]
+		restored++
+	}
+
+	h.UnLeased = db.UnLeased
+
+	log.Infof("Restored [%d] DHCP leases from [%s]", restored, h.LeaseFile)
+	return nil
+}
+
+// saveLeases will remove any expired leases and write the remaining ones to the lease database
+func (h *DHCPSettings) saveLeases() error {
+	if h.LeaseFile == "" {
+		return nil
+	}
+
+	var db leaseDatabase
+	now := time.Now()
+	for _, scope := range h.Scopes {
+		for i := range scope.Leases {
+			if scope.Leases[i].Expiry.Before(now) {
+				delete(scope.Leases, i)
+				continue
+			}
+			db.Leases = append(db.Leases, scope.Leases[i])
+		}
+	}
+	db.UnLeased = h.UnLeased
+
+	b, err := json.Marshal(db)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and then rename it, this ensures that a crash mid-write won't leave a corrupt database
+	tmpFile, err := ioutil.TempFile(filepath.Dir(h.LeaseFile), ".plunder-leases")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(b)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), h.LeaseFile)
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	dhcp "github.com/krolaw/dhcp4"
+)
+
+// wellKnownOption describes an option that can be configured by name, along with how its value is encoded
+type wellKnownOption struct {
+	code       dhcp.OptionCode
+	optionType string
+}
+
+// wellKnownOptions are the options that can be referenced by name rather than by their option code
+var wellKnownOptions = map[string]wellKnownOption{
+	"hostname":       {dhcp.OptionHostName, "string"},
+	"domainName":     {dhcp.OptionDomainName, "string"},
+	"mtu":            {dhcp.OptionInterfaceMTU, "uint16"},
+	"ntpServers":     {dhcp.OptionNetworkTimeProtocolServers, "ips"},
+	"vendorSpecific": {dhcp.OptionVendorSpecificInformation, "hex"},
+	"domainSearch":   {dhcp.OptionDomainSearch, "domains"},
+}
+
+// encode will convert a configured option into its option code and the raw bytes that are sent to a client
+func (o DHCPOption) encode() (dhcp.OptionCode, []byte, error) {
+	var code dhcp.OptionCode
+	optionType := o.Type
+
+	if known, ok := wellKnownOptions[o.Option]; ok {
+		code = known.code
+		if optionType == "" {
+			optionType = known.optionType
+		}
+	} else {
+		c, err := strconv.ParseUint(o.Option, 10, 8)
+		if err != nil || c == 0 || c == 255 {
+			return 0, nil, fmt.Errorf("Unknown DHCP option [%s]", o.Option)
+		}
+		code = dhcp.OptionCode(c)
+	}
+
+	if optionType == "" {
+		// Options that are referenced by code need their type specifying
+		return 0, nil, fmt.Errorf("DHCP option [%s] requires a type", o.Option)
+	}
+
+	value, err := encodeOptionValue(optionType, o.Value)
+	if err != nil {
+		return 0, nil, fmt.Errorf("DHCP option [%s] -> %v", o.Option, err)
+	}
+	if len(value) > 255 {
+		return 0, nil, fmt.Errorf("DHCP option [%s] is too long [%d bytes]", o.Option, len(value))
+	}
+	return code, value, nil
+}
+
+// encodeOptionValue will encode a string value into the wire format of a DHCP option type
+func encodeOptionValue(optionType, value string) ([]byte, error) {
+	switch optionType {
+	case "string":
+		return []byte(value), nil
+	case "ip", "ips":
+		var b []byte
+		for _, address := range strings.Split(value, ",") {
+			ip := net.ParseIP(strings.TrimSpace(address)).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("Couldn't parse the IP address: %s", address)
+			}
+			b = append(b, ip...)
+		}
+		return b, nil
+	case "uint8":
+		i, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{byte(i)}, nil
+	case "uint16":
+		i, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(i))
+		return b, nil
+	case "uint32":
+		i, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(i))
+		return b, nil
+	case "bool":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		if enabled {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case "hex":
+		return hex.DecodeString(strings.Replace(value, ":", "", -1))
+	case "domains":
+		return encodeDomainSearch(value)
+	}
+	return nil, fmt.Errorf("Unknown option type [%s]", optionType)
+}
+
+// encodeDomainSearch will encode a comma separated list of domains as per RFC 3397 (without compression)
+func encodeDomainSearch(value string) ([]byte, error) {
+	var b []byte
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.Trim(strings.TrimSpace(domain), ".")
+		if domain == "" {
+			continue
+		}
+		for _, label := range strings.Split(domain, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("Invalid domain [%s]", domain)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b, nil
+}
+
+// applyOptions will encode a list of configured options and add them to a set of DHCP options
+func applyOptions(options dhcp.Options, configured []DHCPOption) error {
+	for i := range configured {
+		code, value, err := configured[i].encode()
+		if err != nil {
+			return err
+		}
+		options[code] = value
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"plunder-app/plunder/pkg/utils"
+
+	dhcp "github.com/krolaw/dhcp4"
+)
+
+// DHCPScope - contains the pool of addresses and the options for a single network
+type DHCPScope struct {
+	Name    string       // Name of the scope (used for logging)
+	Options dhcp.Options // Options to send to DHCP Clients
+	Start   net.IP       // Start of IP range to distribute
+
+	LeaseRange int           // Number of IPs to distribute (starting from start)
+	Leases     map[int]Lease // Map to keep track of leases
+
+	network *net.IPNet // The network this scope serves, used to match relayed requests
+}
+
+// newDHCPScope will parse a scope configuration and create the pool and options for it
+func newDHCPScope(config dhcpScope) (*DHCPScope, error) {
+	s := &DHCPScope{
+		Name:       config.ScopeName,
+		LeaseRange: config.DHCPLeasePool,
+		Leases:     make(map[int]Lease, config.DHCPLeasePool),
+		Options:    dhcp.Options{},
+	}
+
+	if s.LeaseRange < 1 {
+		return nil, fmt.Errorf("Scope [%s] requires at least one available lease", s.Name)
+	}
+
+	// Start address of DHCP Range
+	ip, err := utils.ConvertIP(config.DHCPStartAddress)
+	if err != nil {
+		return nil, fmt.Errorf("DHCP Start Address -> %v", err)
+	}
+	s.Start = ip
+
+	// Subnet
+	subnet, err := utils.ConvertIP(config.DHCPSubnet)
+	if err != nil {
+		return nil, fmt.Errorf("DHCP Subnet -> %v", err)
+	}
+	s.Options[dhcp.OptionSubnetMask] = subnet
+
+	// The network is used to match the relay agent address (giaddr) to this scope
+	mask := net.IPMask(subnet)
+	s.network = &net.IPNet{
+		IP:   s.Start.Mask(mask),
+		Mask: mask,
+	}
+
+	// Gateway / Router
+	ip, err = utils.ConvertIP(config.DHCPGateway)
+	if err != nil {
+		return nil, fmt.Errorf("DHCP Gateway -> %v", err)
+	}
+	s.Options[dhcp.OptionRouter] = ip
+
+	// DNS
+	ip, err = utils.ConvertIP(config.DHCPDNS)
+	if err != nil {
+		return nil, fmt.Errorf("DHCP DNS ->%v", err)
+	}
+	s.Options[dhcp.OptionDomainNameServer] = ip
+
+	// Any additional options for this scope
+	err = applyOptions(s.Options, config.DHCPOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// findScope will find the scope for a request, requests that have come through a DHCP relay are matched using the
+// relay agent address (giaddr). Anything else is either a renewal (matched with the client address) or is local.
+func (h *DHCPSettings) findScope(p dhcp.Packet) *DHCPScope {
+	if len(h.Scopes) == 0 {
+		return nil
+	}
+
+	address := p.GIAddr()
+	if address.Equal(net.IPv4zero) {
+		address = p.CIAddr()
+		if address.Equal(net.IPv4zero) {
+			// No relay or client address, so the request is from the local network
+			return h.Scopes[0]
+		}
+	}
+
+	for i := range h.Scopes {
+		if h.Scopes[i].network.Contains(address) {
+			return h.Scopes[i]
+		}
+	}
+
+	// A client renewing an address from outside of any scope (e.g. a static reservation) is treated as local
+	if p.GIAddr().Equal(net.IPv4zero) {
+		return h.Scopes[0]
+	}
+	return nil
+}
+
+// findScopeForAddress will find the scope whose pool contains an address
+func (h *DHCPSettings) findScopeForAddress(ip net.IP) (*DHCPScope, int) {
+	for i := range h.Scopes {
+		if leaseNum := dhcp.IPRange(h.Scopes[i].Start, ip) - 1; leaseNum >= 0 && leaseNum < h.Scopes[i].LeaseRange {
+			return h.Scopes[i], leaseNum
+		}
+	}
+	return nil, -1
+}
+
+func (s *DHCPScope) freeLease() int {
+	now := time.Now()
+	b := rand.Intn(s.LeaseRange) // Try random first
+	for _, v := range [][]int{{b, s.LeaseRange}, {0, b}} {
+		for i := v[0]; i < v[1]; i++ {
+			if l, ok := s.Leases[i]; !ok || l.Expiry.Before(now) {
+				// Addresses reserved for a deployment are never handed out from the pool
+				if isReservedAddress(dhcp.IPAdd(s.Start, i)) {
+					continue
+				}
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -1,8 +1,11 @@
 package services
 
 import (
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"path"
 	"path/filepath"
 
 	"plunder-app/plunder/pkg/utils"
@@ -24,7 +27,7 @@ func (c *BootController) generateBootTypeHanders() {
 	// Find the default configuration
 	defaultConfig := findBootConfigForType("default")
 	if defaultConfig != nil {
-		defaultBoot = utils.IPXEPreeseed(*c.HttpAddress, defaultConfig.Kernel, defaultConfig.Initrd, defaultConfig.Cmdline)
+		defaultBoot = bootTypeIPXE(defaultConfig, utils.IPXEPreeseed(*c.HttpAddress, defaultConfig.Kernel, defaultConfig.Initrd, defaultConfig.Cmdline))
 	} //else {
 	//	log.Warnf("Found [%d] configurations and no \"default\" configuration", len(c.BootConfigs))
 	//}
@@ -32,23 +35,33 @@ func (c *BootController) generateBootTypeHanders() {
 	// If a preeseed configuration has been configured then add it, and create a HTTP endpoint
 	preeseedConfig := findBootConfigForType("preseed")
 	if preeseedConfig != nil {
-		preseed = utils.IPXEPreeseed(*c.HttpAddress, preeseedConfig.Kernel, preeseedConfig.Initrd, preeseedConfig.Cmdline)
+		preseed = bootTypeIPXE(preeseedConfig, utils.IPXEPreeseed(*c.HttpAddress, preeseedConfig.Kernel, preeseedConfig.Initrd, preeseedConfig.Cmdline))
 
 	}
 
 	// If a kickstart configuration has been configured then add it, and create a HTTP endpoint
 	kickstartConfig := findBootConfigForType("kickstart")
 	if kickstartConfig != nil {
-		kickstart = utils.IPXEPreeseed(*c.HttpAddress, kickstartConfig.Kernel, kickstartConfig.Initrd, kickstartConfig.Cmdline)
+		kickstart = bootTypeIPXE(kickstartConfig, utils.IPXEPreeseed(*c.HttpAddress, kickstartConfig.Kernel, kickstartConfig.Initrd, kickstartConfig.Cmdline))
 	}
 
 	// If a vsphereConfig configuration has been configured then add it, and create a HTTP endpoint
 	vsphereConfig := findBootConfigForType("vsphere")
 	if vsphereConfig != nil {
-		vsphere = utils.IPXEVSphere(*c.HttpAddress, vsphereConfig.Kernel, vsphereConfig.Cmdline)
+		vsphere = bootTypeIPXE(vsphereConfig, utils.IPXEVSphere(*c.HttpAddress, vsphereConfig.Kernel, vsphereConfig.Cmdline))
 	}
 }
 
+// bootTypeIPXE will render the iPXE template of a boot configuration, the built-in script is used if the template fails
+func bootTypeIPXE(config *BootConfig, builtin string) string {
+	script, err := config.buildIPXE("", HostConfig{}, builtin)
+	if err != nil {
+		log.Errorf("Using the built-in iPXE script for Boot Config [%s] -> %v", config.ConfigName, err)
+		return builtin
+	}
+	return script
+}
+
 func (c *BootController) serveHTTP() error {
 
 	// This function will pre-generate the boot handlers for the various boot types
@@ -76,6 +89,12 @@ func (c *BootController) serveHTTP() error {
 	serveMux.HandleFunc("/preseed.ipxe", preseedHandler)
 	serveMux.HandleFunc("/vsphere.ipxe", vsphereHandler)
 
+	// Installers call /installing/<mac> once they have started, /complete/<mac> once they have finished, or
+	// /failed/<mac> if the installation fails
+	serveMux.HandleFunc("/installing/", installingHandler)
+	serveMux.HandleFunc("/complete/", completeHandler)
+	serveMux.HandleFunc("/failed/", failedHandler)
+
 	// Set the pointer to the boot config
 	controller = c
 
@@ -84,15 +103,20 @@ func (c *BootController) serveHTTP() error {
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Requested URL [%s]", r.RequestURI)
+	publishRequestEvent(r)
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "text/plain")
 	// Return the preseed content
 	log.Debugf("Requested URL [%s]", r.URL.Host)
-	io.WriteString(w, httpPaths[r.URL.Path])
+	deploymentsLock.RLock()
+	content := httpPaths[r.URL.Path]
+	deploymentsLock.RUnlock()
+	io.WriteString(w, content)
 }
 
 func preseedHandler(w http.ResponseWriter, r *http.Request) {
+	publishRequestEvent(r)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "text/plain")
 	// Return the preseed content
@@ -100,6 +124,7 @@ func preseedHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func kickstartHandler(w http.ResponseWriter, r *http.Request) {
+	publishRequestEvent(r)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "text/plain")
 	// Return the kickstart content
@@ -107,6 +132,7 @@ func kickstartHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func vsphereHandler(w http.ResponseWriter, r *http.Request) {
+	publishRequestEvent(r)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "text/plain")
 	// Return the vsphere content
@@ -114,6 +140,7 @@ func vsphereHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func defaultBootHandler(w http.ResponseWriter, r *http.Request) {
+	publishRequestEvent(r)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "text/plain")
 	// Return the default boot content
@@ -121,6 +148,7 @@ func defaultBootHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func rebootHandler(w http.ResponseWriter, r *http.Request) {
+	publishRequestEvent(r)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "text/plain")
 	// Return the reboot content
@@ -128,12 +156,89 @@ func rebootHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func autoBootHandler(w http.ResponseWriter, r *http.Request) {
+	publishRequestEvent(r)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "text/plain")
 	// Return the reboot content
 	io.WriteString(w, autoBoot)
 }
 
+// completeHandler is called by an installer once it has finished (e.g. /complete/00-11-22-33-44-55), the deployment
+// is marked as installed so that the server boots from its local disk when it restarts
+func completeHandler(w http.ResponseWriter, r *http.Request) {
+	if !installerCallbackMethod(w, r) {
+		return
+	}
+	hwAddr, err := net.ParseMAC(path.Base(r.URL.Path))
+	if err == nil {
+		err = SetDeploymentInstalled(hwAddr.String(), true)
+	}
+	if err != nil {
+		log.Errorf("Unable to complete the installation for [%s] -> %v", r.URL.Path, err)
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, err.Error())
+		return
+	}
+	log.Infof("Mac address [%s] has completed its installation and will boot from its local disk", hwAddr.String())
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok")
+}
+
+// installingHandler is called by an installer once it has started (e.g. /installing/00-11-22-33-44-55), the
+// deployment is moved to the installing state
+func installingHandler(w http.ResponseWriter, r *http.Request) {
+	if mac := installerEvent(w, r, EventInstalling); mac != "" {
+		log.Infof("Mac address [%s] has started its installation", mac)
+	}
+}
+
+// failedHandler is called by an installer when the installation fails (e.g. /failed/00-11-22-33-44-55), the
+// deployment is moved to the failed state
+func failedHandler(w http.ResponseWriter, r *http.Request) {
+	if mac := installerEvent(w, r, EventFailed); mac != "" {
+		log.Errorf("Mac address [%s] has failed its installation", mac)
+	}
+}
+
+// installerEvent publishes an event for the deployment in the URL of an installer callback, the mac address is
+// returned (or blank if the callback has been rejected)
+func installerEvent(w http.ResponseWriter, r *http.Request, eventType string) string {
+	if !installerCallbackMethod(w, r) {
+		return ""
+	}
+	var deployment *DeploymentConfig
+	hwAddr, err := net.ParseMAC(path.Base(r.URL.Path))
+	if err == nil {
+		deployment = findDeploymentFromMac(hwAddr.String())
+	}
+	if deployment == nil {
+		log.Errorf("Unable to find the deployment for [%s]", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, fmt.Sprintf("Unable to find the deployment for [%s]", r.URL.Path))
+		return ""
+	}
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	publishEvent(eventType, hwAddr.String(), address, deployment.ConfigName)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok")
+	return hwAddr.String()
+}
+
+// installerCallbackMethod ensures that the installer callbacks are a POST, so that they aren't triggered by anything
+// that just follows links (e.g. a crawler or browser prefetch)
+func installerCallbackMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodPost)
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	io.WriteString(w, fmt.Sprintf("Method [%s] isn't allowed, installers should POST to [%s]", r.Method, r.URL.Path))
+	return false
+}
+
 // HealthCheckHandler -
 func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	// A very simple health check.
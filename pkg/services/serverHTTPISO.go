@@ -1,25 +1,100 @@
 package services
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/hooklift/iso9660"
 	log "github.com/sirupsen/logrus"
 )
 
-// TODO - This currently is inefficient and results in an open/parse of an iso for every file operation.
-// github.com/qeedquan/iso9660 may need looking at later on. ( thebsdbox / [1/9/19] )
-// Comments are left incase we/I revert
+// isoImage is an ISO that has had its directory tree indexed, files are read directly from their extent in the image
+type isoImage struct {
+	path     string
+	file     *os.File
+	size     int64
+	modTime  time.Time
+	volumeID string
+
+	// files is indexed by the full (Rock Ridge / Joliet) path, isoNames by the iso9660 path
+	files    map[string]*isoFile
+	isoNames map[string]*isoFile
+
+	rockRidge bool
+	joliet    bool
+	udf       bool
+}
+
+// isoFile is the location of a file (or directory) within an ISO image
+type isoFile struct {
+	name    string
+	offset  int64
+	size    int64
+	modTime time.Time
+	dir     bool
+	extents []isoExtent // A file that is split into extents that may not be contiguous (otherwise nil)
+}
 
-// isoMapper at this point just maps the prefix to the path this may change
-var isoMapper map[string]string
+// isoExtent is a part of a file within an ISO image
+type isoExtent struct {
+	offset int64
+	size   int64
+}
+
+// reader returns a reader for the contents of a file
+func (image *isoImage) reader(f *isoFile) *io.SectionReader {
+	if len(f.extents) == 0 {
+		return io.NewSectionReader(image.file, f.offset, f.size)
+	}
+	return io.NewSectionReader(isoExtentReader{file: image.file, extents: f.extents}, 0, f.size)
+}
+
+// isoExtentReader reads a file from its extents, as if they were contiguous
+type isoExtentReader struct {
+	file    io.ReaderAt
+	extents []isoExtent
+}
+
+// ReadAt - reads from each of the extents that overlap the requested range
+func (e isoExtentReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for _, extent := range e.extents {
+		if len(p) == 0 {
+			break
+		}
+		if off >= extent.size {
+			off -= extent.size
+			continue
+		}
+		chunk := p
+		if int64(len(chunk)) > extent.size-off {
+			chunk = chunk[:extent.size-off]
+		}
+		read, err := e.file.ReadAt(chunk, extent.offset+off)
+		n += read
+		if err != nil && !(err == io.EOF && read == len(chunk)) {
+			return n, err
+		}
+		p = p[read:]
+		off = 0
+	}
+	if len(p) != 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// isoMapper maps the URL prefix to an indexed ISO image
+var isoMapper map[string]*isoImage
+
+// isoMapperLock protects the isoMapper, as ISOs can be added through the API whilst files are being served
+var isoMapperLock sync.RWMutex
 
 // iso9660PathSanitiser will take a "standard" file path and convert it into something that make sense within iso9660 TOC
 // The iso9660 constraints:
@@ -82,110 +157,95 @@ func iso9660PathSanitiser(unsanitisedPath string) string {
 // ISOReader -
 func isoReader(w http.ResponseWriter, r *http.Request) {
 
-	// Sanitise the URL, there are a number of steps involved with turning the url into something we can use
-	// Remove the beginning slash
-	rawURL := strings.TrimLeft(r.URL.String(), "/")
-
-	// Unescape the Http query
-	isoURL, err := url.QueryUnescape(rawURL)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		io.WriteString(w, fmt.Sprintf("%s", err.Error()))
-		log.Error(err)
+	// Remove the beginning slash, the path has already been unescaped
+	isoURL := strings.TrimLeft(r.URL.Path, "/")
 
+	// Split the URL to find the prefix (first part of the URL)
+	urlElements := strings.SplitN(isoURL, "/", 2)
+	if len(urlElements) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, fmt.Sprintf("Unable to find content ISO Prefix %s", isoURL))
 		return
 	}
+	isoPrefix := urlElements[0]
 
-	// Split the URL to find the prefix (first part of the URL)
-	urlElements := strings.Split(isoURL, "/")
-	// Ensure the URL can be parsed
-	if len(urlElements) > 1 {
-		isoPrefix := urlElements[0]
-
-		isoPath := iso9660PathSanitiser(strings.Replace(isoURL, isoPrefix, "", 1))
-
-		// We now have the ISO prefix to look up files, and the path to look up in the ISO
-		// Check for ISO
-		log.Debugf("Original URL: %s ISO Path: %s", isoURL, isoPath)
-		if _, ok := isoMapper[isoPrefix]; ok {
-			file, err := os.Open(isoMapper[isoPrefix])
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				io.WriteString(w, fmt.Sprintf("%s", err.Error()))
-				log.Error(err)
-				return
-			}
-			defer file.Close()
-			r, err := iso9660.NewReader(file)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			for {
-
-				f, err := r.Next()
-				if err == io.EOF {
-					w.WriteHeader(http.StatusNotFound)
-					io.WriteString(w, fmt.Sprintf("Unable to read/find file %s", isoPath))
-					log.Error(fmt.Sprintf("Unable to read/find file %s", isoPath))
-					return
-				}
-				if err != nil {
-					log.Error(err)
-					return
-				}
-
-				if f.Name() == isoPath {
-					freader := f.Sys().(io.Reader)
-					buf := new(bytes.Buffer)
-					buf.ReadFrom(freader)
-					w.WriteHeader(http.StatusOK)
-					w.Header().Set("Content-Type", "application/x-binary")
-					io.WriteString(w, buf.String())
-					return
-				}
-			}
-			// isoFile, err := isoMapper[isoPrefix].Open(isoPath)
-			// if err != nil {
-			// 	w.WriteHeader(http.StatusNotFound)
-			// 	io.WriteString(w, fmt.Sprintf("%s", err.Error()))
-			// 	return
-			// }
-
-			// fileStat, err := isoFile.Stat()
-			// if err != nil {
-			// 	w.WriteHeader(http.StatusNotFound)
-			// 	io.WriteString(w, fmt.Sprintf("Unable to stat file on ISO %s", isoPath))
-			// 	return
-			// }
-			// fileBytes = make([]byte, fileStat.Size())
-			// _, err = isoFile.Read(fileBytes)
-			// if err != nil {
-			// 	w.WriteHeader(http.StatusNotFound)
-			// 	io.WriteString(w, fmt.Sprintf("Unable to read file on ISO %s", isoPath))
-			// 	return
-			// }
-		}
+	isoMapperLock.RLock()
+	image := isoMapper[isoPrefix]
+	isoMapperLock.RUnlock()
 
-	} else {
+	if image == nil {
 		w.WriteHeader(http.StatusNotFound)
 		io.WriteString(w, fmt.Sprintf("Unable to find content ISO Prefix %s", isoURL))
 		return
 	}
-	w.WriteHeader(http.StatusNotFound)
-	io.WriteString(w, fmt.Sprintf("Unable to find content ISO Prefix %s", isoURL))
-	return
+
+	// Look up the full path first, falling back to the iso9660 name for plain images
+	isoPath := path.Clean("/" + urlElements[1])
+	f, ok := image.files[isoPath]
+	if !ok {
+		isoPath = path.Clean(iso9660PathSanitiser(isoPath))
+		f, ok = image.isoNames[isoPath]
+	}
+	log.Debugf("Original URL: %s ISO Path: %s", isoURL, isoPath)
+
+	if !ok || f.dir {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, fmt.Sprintf("Unable to read/find file %s", isoPath))
+		log.Errorf("Unable to read/find file %s", isoPath)
+		return
+	}
+
+	// ServeContent handles HEAD, Range and If-Modified-Since requests, the content is streamed directly from the ISO
+	w.Header().Set("Content-Type", "application/x-binary")
+	http.ServeContent(w, r, f.name, f.modTime, image.reader(f))
 }
 
-// OpenISO will open an iso and add it to out ISO Map for reading at a later point
+// isoImageReader serves an entire ISO (e.g. /ubuntu.iso), for installers that download the ISO rather than its contents
+func isoImageReader(w http.ResponseWriter, r *http.Request) {
+	isoPrefix := strings.TrimSuffix(strings.TrimLeft(r.URL.Path, "/"), ".iso")
+
+	isoMapperLock.RLock()
+	image := isoMapper[isoPrefix]
+	isoMapperLock.RUnlock()
+
+	if image == nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, fmt.Sprintf("Unable to find ISO Prefix %s", isoPrefix))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-iso9660-image")
+	http.ServeContent(w, r, filepath.Base(image.path), image.modTime, io.NewSectionReader(image.file, 0, image.size))
+}
+
+// OpenISO will open an iso and add it to out ISO Map for reading at a later point, the directory tree of the iso is
+// indexed once so that files can be found without reading through the iso
 func OpenISO(isoPath, isoPrefix string) error {
 	// Check that the file exists
-
-	_, err := os.Stat(isoPath)
+	info, err := os.Stat(isoPath)
 	// We could use os.IsNotExist() but we may as well capture all errors
 	if err != nil {
 		return fmt.Errorf("Error reading file [%s]", isoPath)
 	}
 
+	isoMapperLock.Lock()
+	defer isoMapperLock.Unlock()
+
+	if isoMapper == nil {
+		isoMapper = make(map[string]*isoImage)
+	}
+
+	// Re-use the existing index if the iso hasn't changed
+	if existing := isoMapper[isoPrefix]; existing != nil && existing.path == isoPath && existing.size == info.Size() && existing.modTime.Equal(info.ModTime()) {
+		return nil
+	}
+
+	image, err := indexISO(isoPath)
+	if err != nil {
+		return err
+	}
+
+	// Any previous image isn't closed, as it may still be serving files (it will be closed once no longer referenced)
+	isoMapper[isoPrefix] = image
 	return nil
 }
@@ -0,0 +1,476 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The iso9660 directory tree only holds short (8.3 style) upper case names, the full names are held by either the
+// Rock Ridge extensions (in the system use area of each directory record) or by a separate Joliet directory tree that
+// is found through a supplementary volume descriptor.
+
+const (
+	// isoSectorSize is the size of a logical block within an ISO image
+	isoSectorSize = 2048
+	// isoDescriptorStart is the sector of the first volume descriptor
+	isoDescriptorStart = 16
+
+	isoDescriptorPrimary       = 1
+	isoDescriptorSupplementary = 2
+	isoDescriptorTerminator    = 255
+
+	isoFlagDirectory   = 0x02
+	isoFlagMultiExtent = 0x80
+
+	// isoMaxDepth limits how deep a directory tree is walked
+	isoMaxDepth = 64
+	// isoMaxContinuations limits how many Rock Ridge continuation areas are followed for a single record
+	isoMaxContinuations = 16
+	// isoMaxDirectorySize is the largest directory extent that will be read
+	isoMaxDirectorySize = 16 * 1024 * 1024
+)
+
+// isoDirectoryRecord is a parsed directory record, along with any Rock Ridge entries
+type isoDirectoryRecord struct {
+	name      string // iso9660 or Joliet name (without the version)
+	rrName    string // Rock Ridge alternate name (NM)
+	location  uint32
+	size      int64
+	extAttr   byte
+	flags     byte
+	recorded  [7]byte
+	childLink uint32 // Rock Ridge child link (CL), the real location of a relocated directory
+	relocated bool   // Rock Ridge relocated directory (RE), this is reached through a child link instead
+	extents   []isoExtent
+}
+
+// extent returns the location of the data for a record within the image
+func (r *isoDirectoryRecord) extent() isoExtent {
+	return isoExtent{offset: (int64(r.location) + int64(r.extAttr)) * isoSectorSize, size: r.size}
+}
+
+// isoVolume is the root of a directory tree from a volume descriptor
+type isoVolume struct {
+	root isoDirectoryRecord
+}
+
+// indexISO will open an iso and read its directory tree
+func indexISO(isoPath string) (*isoImage, error) {
+	file, err := os.Open(isoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	image := &isoImage{
+		path:     isoPath,
+		file:     file,
+		size:     info.Size(),
+		modTime:  info.ModTime(),
+		files:    make(map[string]*isoFile),
+		isoNames: make(map[string]*isoFile),
+	}
+
+	err = image.index()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Unable to read ISO [%s] -> %v", isoPath, err)
+	}
+	log.Infof("Indexed [%d] files in ISO [%s], Rock Ridge [%t] Joliet [%t] UDF [%t]", len(image.files), isoPath, image.rockRidge, image.joliet, image.udf)
+
+	return image, nil
+}
+
+// index reads the volume descriptors and then walks the directory trees, the long names come from Rock Ridge if it
+// is present, then Joliet and finally the iso9660 names are used for plain images. A UDF file system replaces the
+// iso9660 tree (the iso9660 names are kept), as the iso9660 tree of a UDF bridge image may be a placeholder.
+func (image *isoImage) index() error {
+	primary, joliet, err := image.readVolumeDescriptors()
+	if err != nil {
+		return err
+	}
+
+	if primary != nil {
+		// Rock Ridge is identified by a "SP" entry in the system use area of the root directory's "." record
+		rrSkip, err := image.rockRidgeSkip(primary.root)
+		if err != nil {
+			return err
+		}
+		image.rockRidge = rrSkip >= 0
+		image.joliet = joliet != nil
+
+		err = image.walk(primary.root, false, rrSkip, "", "", 0, map[uint32]bool{})
+		if err != nil {
+			return err
+		}
+
+		if !image.rockRidge && joliet != nil {
+			// Replace the iso9660 names with the Joliet names
+			image.files = make(map[string]*isoFile)
+			err = image.walk(joliet.root, true, -1, "", "", 0, map[uint32]bool{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	image.udf, err = image.indexUDF()
+	if err != nil {
+		return err
+	}
+	if primary == nil && !image.udf {
+		return fmt.Errorf("No iso9660 or UDF file system found")
+	}
+	return nil
+}
+
+// readVolumeDescriptors finds the primary volume descriptor and (if present) the Joliet supplementary descriptor, there
+// are no descriptors for an image that only has a UDF file system
+func (image *isoImage) readVolumeDescriptors() (*isoVolume, *isoVolume, error) {
+	var primary, joliet *isoVolume
+	buf := make([]byte, isoSectorSize)
+
+	for sector := int64(isoDescriptorStart); ; sector++ {
+		if _, err := image.file.ReadAt(buf, sector*isoSectorSize); err != nil {
+			return nil, nil, fmt.Errorf("Unable to read volume descriptor -> %v", err)
+		}
+		if string(buf[1:6]) != "CD001" {
+			if sector == isoDescriptorStart {
+				return nil, nil, nil
+			}
+			return nil, nil, fmt.Errorf("Invalid volume descriptor at sector [%d]", sector)
+		}
+
+		switch buf[0] {
+		case isoDescriptorPrimary:
+			if primary == nil {
+				root, _, err := parseISORecord(buf[156:190], false, -1)
+				if err != nil {
+					return nil, nil, err
+				}
+				primary = &isoVolume{root: root}
+				image.volumeID = strings.TrimSpace(string(buf[40:72]))
+			}
+		case isoDescriptorSupplementary:
+			// Joliet is identified by the UCS-2 escape sequences
+			escape := string(buf[88:91])
+			if joliet == nil && (escape == "%/@" || escape == "%/C" || escape == "%/E") {
+				root, _, err := parseISORecord(buf[156:190], true, -1)
+				if err != nil {
+					return nil, nil, err
+				}
+				joliet = &isoVolume{root: root}
+			}
+		case isoDescriptorTerminator:
+			if primary == nil {
+				return nil, nil, fmt.Errorf("No primary volume descriptor found")
+			}
+			return primary, joliet, nil
+		}
+	}
+}
+
+// rockRidgeSkip returns the number of bytes to skip in the system use area of each record (from the SP entry), or -1
+// if the image doesn't use Rock Ridge
+func (image *isoImage) rockRidgeSkip(root isoDirectoryRecord) (int, error) {
+	buf, err := image.readExtent(root.location, isoSectorSize)
+	if err != nil {
+		return -1, err
+	}
+	length := int(buf[0])
+	if length < 34 || length > len(buf) {
+		return -1, nil
+	}
+	systemUse := buf[34:length]
+	if len(systemUse) >= 7 && string(systemUse[0:2]) == "SP" && systemUse[4] == 0xBE && systemUse[5] == 0xEF {
+		return int(systemUse[6]), nil
+	}
+	return -1, nil
+}
+
+// walk will read a directory and add all of its entries to the index, before walking any sub-directories. The
+// iso9660 (short name) path is tracked alongside the long name path so that both indexes can be built at once.
+func (image *isoImage) walk(dir isoDirectoryRecord, joliet bool, rrSkip int, longPath, shortPath string, depth int, visited map[uint32]bool) error {
+	if depth > isoMaxDepth {
+		return fmt.Errorf("Directory tree is deeper than %d levels", isoMaxDepth)
+	}
+	if visited[dir.location] {
+		// A loop in the directory tree, it has already been indexed
+		return nil
+	}
+	visited[dir.location] = true
+
+	records, err := image.readDirectory(dir, joliet, rrSkip)
+	if err != nil {
+		return err
+	}
+
+	for i := range records {
+		r := records[i]
+		if r.relocated {
+			// This directory is indexed through the child link in its original location
+			continue
+		}
+
+		if r.childLink != 0 {
+			// A relocated (deep) directory, the record is a placeholder for the real directory
+			linked, err := image.readDotRecord(r.childLink)
+			if err != nil {
+				return err
+			}
+			r.location = linked.location
+			r.size = linked.size
+			r.flags |= isoFlagDirectory
+		}
+
+		name := r.name
+		if r.rrName != "" {
+			name = r.rrName
+		} else if !joliet && rrSkip < 0 {
+			// Plain iso9660 names are displayed in lower case (as they are when an iso is mounted)
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+		}
+
+		f := &isoFile{
+			name:    name,
+			offset:  r.extent().offset,
+			size:    r.size,
+			modTime: isoRecordedTime(r.recorded, image.modTime),
+			dir:     r.flags&isoFlagDirectory != 0,
+			extents: r.extents,
+		}
+
+		filePath := path.Join("/", longPath, name)
+		image.files[filePath] = f
+
+		// The iso9660 names are kept in the format produced by the iso9660PathSanitiser
+		var shortFilePath string
+		if !joliet {
+			shortFilePath = path.Join("/", shortPath, strings.ToLower(r.name))
+			image.isoNames[shortFilePath] = f
+		}
+
+		if f.dir {
+			err = image.walk(r, joliet, rrSkip, filePath, shortFilePath, depth+1, visited)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readExtent reads an extent from the image
+func (image *isoImage) readExtent(location uint32, size int64) ([]byte, error) {
+	if size > isoMaxDirectorySize {
+		return nil, fmt.Errorf("Directory extent at sector [%d] is too large [%d bytes]", location, size)
+	}
+	buf := make([]byte, size)
+	n, err := image.file.ReadAt(buf, int64(location)*isoSectorSize)
+	if err != nil && !(err == io.EOF && int64(n) == size) {
+		return nil, fmt.Errorf("Unable to read extent at sector [%d] -> %v", location, err)
+	}
+	return buf, nil
+}
+
+// readDotRecord reads the "." record of a directory, this describes the directory itself
+func (image *isoImage) readDotRecord(location uint32) (isoDirectoryRecord, error) {
+	buf, err := image.readExtent(location, isoSectorSize)
+	if err != nil {
+		return isoDirectoryRecord{}, err
+	}
+	r, _, err := parseISORecord(buf, false, -1)
+	return r, err
+}
+
+// readDirectory reads all of the records in a directory, skipping the "." and ".." records
+func (image *isoImage) readDirectory(dir isoDirectoryRecord, joliet bool, rrSkip int) ([]isoDirectoryRecord, error) {
+	buf, err := image.readExtent(dir.location, dir.size)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []isoDirectoryRecord
+	var multiExtent bool
+	for offset := 0; offset < len(buf); {
+		length := int(buf[offset])
+		if length == 0 {
+			// Records don't cross sectors, the rest of this sector is padding
+			offset = (offset/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		if offset+length > len(buf) {
+			return nil, fmt.Errorf("Directory record at sector [%d] is corrupt", dir.location)
+		}
+
+		r, systemUse, err := parseISORecord(buf[offset:offset+length], joliet, rrSkip)
+		if err != nil {
+			return nil, err
+		}
+		offset += length
+
+		if r.name == "\x00" || r.name == "\x01" {
+			continue
+		}
+
+		if rrSkip >= 0 {
+			err = image.parseRockRidge(&r, systemUse)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Files larger than 4GB are split into multiple extents with the same name, the extents don't have to be
+		// contiguous (or in order within the image)
+		if multiExtent && len(records) != 0 && records[len(records)-1].name == r.name {
+			previous := &records[len(records)-1]
+			if previous.extents == nil {
+				previous.extents = []isoExtent{previous.extent()}
+			}
+			previous.extents = append(previous.extents, r.extent())
+			previous.size += r.size
+		} else {
+			records = append(records, r)
+		}
+		multiExtent = r.flags&isoFlagMultiExtent != 0
+	}
+	return records, nil
+}
+
+// parseISORecord parses a single directory record, returning the record and its system use area
+func parseISORecord(b []byte, joliet bool, rrSkip int) (isoDirectoryRecord, []byte, error) {
+	var r isoDirectoryRecord
+	if len(b) < 34 || int(b[0]) > len(b) {
+		return r, nil, fmt.Errorf("Directory record is too short")
+	}
+	length := int(b[0])
+	nameLength := int(b[32])
+	if 33+nameLength > length {
+		return r, nil, fmt.Errorf("Directory record name is corrupt")
+	}
+
+	r.extAttr = b[1]
+	r.location = binary.LittleEndian.Uint32(b[2:6])
+	r.size = int64(binary.LittleEndian.Uint32(b[10:14]))
+	copy(r.recorded[:], b[18:25])
+	r.flags = b[25]
+
+	name := b[33 : 33+nameLength]
+	if nameLength == 1 && (name[0] == 0 || name[0] == 1) {
+		// The "." and ".." records
+		r.name = string(name)
+	} else if joliet {
+		r.name = stripISOVersion(decodeUCS2(name))
+	} else {
+		r.name = stripISOVersion(string(name))
+	}
+
+	// The system use area follows the name (and a padding byte if the name has an even length)
+	systemUseStart := 33 + nameLength
+	if nameLength%2 == 0 {
+		systemUseStart++
+	}
+	if rrSkip > 0 {
+		systemUseStart += rrSkip
+	}
+	if systemUseStart >= length {
+		return r, nil, nil
+	}
+	return r, b[systemUseStart:length], nil
+}
+
+// parseRockRidge will parse the System Use Sharing Protocol entries for the Rock Ridge name, child link and
+// relocation entries, following any continuation areas
+func (image *isoImage) parseRockRidge(r *isoDirectoryRecord, systemUse []byte) error {
+	var name bytes.Buffer
+	for continuations := 0; systemUse != nil; continuations++ {
+		if continuations > isoMaxContinuations {
+			return fmt.Errorf("Too many Rock Ridge continuation areas for [%s]", r.name)
+		}
+
+		var next []byte
+		for len(systemUse) >= 4 {
+			length := int(systemUse[2])
+			if length < 4 || length > len(systemUse) {
+				break
+			}
+			data := systemUse[4:length]
+
+			switch string(systemUse[0:2]) {
+			case "NM":
+				// Flags: 1 = continues in the next NM entry, 2 = current directory, 4 = parent directory
+				if len(data) >= 1 && data[0]&0x06 == 0 {
+					name.Write(data[1:])
+				}
+			case "CE":
+				if len(data) >= 24 {
+					location := binary.LittleEndian.Uint32(data[0:4])
+					offset := binary.LittleEndian.Uint32(data[8:12])
+					size := binary.LittleEndian.Uint32(data[16:20])
+					area, err := image.readExtent(location, int64(offset)+int64(size))
+					if err != nil {
+						return err
+					}
+					next = area[offset:]
+				}
+			case "CL":
+				if len(data) >= 4 {
+					r.childLink = binary.LittleEndian.Uint32(data[0:4])
+				}
+			case "RE":
+				r.relocated = true
+			case "ST":
+				length = len(systemUse)
+			}
+			systemUse = systemUse[length:]
+		}
+		systemUse = next
+	}
+
+	if name.Len() != 0 {
+		r.rrName = name.String()
+	}
+	return nil
+}
+
+// decodeUCS2 converts a big endian UCS-2 (Joliet) name
+func decodeUCS2(b []byte) string {
+	chars := make([]uint16, len(b)/2)
+	for i := range chars {
+		chars[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+// stripISOVersion removes the version (";1") from the end of a name
+func stripISOVersion(name string) string {
+	if i := strings.LastIndex(name, ";"); i != -1 {
+		return name[:i]
+	}
+	return name
+}
+
+// isoRecordedTime will convert the recording time of a directory record, if the time isn't set then the fallback
+// time is returned
+func isoRecordedTime(t [7]byte, fallback time.Time) time.Time {
+	if t[0] == 0 && t[1] == 0 && t[2] == 0 {
+		return fallback
+	}
+	// The last byte is the offset from GMT in 15 minute intervals
+	zone := time.FixedZone("", int(int8(t[6]))*15*60)
+	return time.Date(1900+int(t[0]), time.Month(t[1]), int(t[2]), int(t[3]), int(t[4]), int(t[5]), 0, zone)
+}
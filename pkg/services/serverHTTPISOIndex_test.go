@@ -0,0 +1,560 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testISO builds an image sector by sector, so that the directory records can be laid out exactly as a test needs
+type testISO struct {
+	sectors [][]byte
+}
+
+// alloc adds empty sectors to the image, returning the first of them
+func (iso *testISO) alloc(n int) uint32 {
+	first := uint32(len(iso.sectors))
+	for i := 0; i < n; i++ {
+		iso.sectors = append(iso.sectors, make([]byte, isoSectorSize))
+	}
+	return first
+}
+
+// write copies data into the image, the data may span several sectors
+func (iso *testISO) write(sector uint32, offset int, data []byte) {
+	for len(data) != 0 {
+		n := copy(iso.sectors[sector][offset:], data)
+		data = data[n:]
+		sector++
+		offset = 0
+	}
+}
+
+// file writes the image to a temporary file
+func (iso *testISO) file(t *testing.T) string {
+	var b bytes.Buffer
+	for i := range iso.sectors {
+		b.Write(iso.sectors[i])
+	}
+	isoPath := filepath.Join(t.TempDir(), "test.iso")
+	if err := ioutil.WriteFile(isoPath, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return isoPath
+}
+
+// newTestISO creates an image with the system area and room for the volume descriptors
+func newTestISO() *testISO {
+	iso := &testISO{}
+	iso.alloc(isoDescriptorStart + 3)
+	return iso
+}
+
+// volumeDescriptor writes a primary (or Joliet supplementary) volume descriptor followed by the terminator
+func (iso *testISO) volumeDescriptor(sector uint32, descriptorType byte, volumeID string, root []byte) {
+	buf := make([]byte, isoSectorSize)
+	buf[0] = descriptorType
+	copy(buf[1:6], "CD001")
+	buf[6] = 1
+	copy(buf[40:72], bytes.Repeat([]byte(" "), 32))
+	copy(buf[40:72], volumeID)
+	if descriptorType == isoDescriptorSupplementary {
+		copy(buf[88:91], "%/E")
+	}
+	copy(buf[156:190], root)
+	iso.write(sector, 0, buf)
+
+	terminator := make([]byte, isoSectorSize)
+	terminator[0] = isoDescriptorTerminator
+	copy(terminator[1:6], "CD001")
+	terminator[6] = 1
+	iso.write(sector+1, 0, terminator)
+}
+
+// testRecord creates a directory record
+func testRecord(name []byte, location, size uint32, flags byte, systemUse []byte) []byte {
+	length := 33 + len(name)
+	if len(name)%2 == 0 {
+		length++
+	}
+	r := make([]byte, length, length+len(systemUse))
+	r = append(r, systemUse...)
+	r[0] = byte(len(r))
+	binary.LittleEndian.PutUint32(r[2:6], location)
+	binary.BigEndian.PutUint32(r[6:10], location)
+	binary.LittleEndian.PutUint32(r[10:14], size)
+	binary.BigEndian.PutUint32(r[14:18], size)
+	copy(r[18:25], []byte{120, 1, 2, 3, 4, 5, 0})
+	r[25] = flags
+	binary.LittleEndian.PutUint16(r[28:30], 1)
+	r[32] = byte(len(name))
+	copy(r[33:], name)
+	return r
+}
+
+// testDirectory creates a directory extent with the "." and ".." records, dotSystemUse is added to the "." record
+func testDirectory(location uint32, dotSystemUse []byte, records ...[]byte) []byte {
+	dir := append(testRecord([]byte{0}, location, isoSectorSize, isoFlagDirectory, dotSystemUse),
+		testRecord([]byte{1}, location, isoSectorSize, isoFlagDirectory, nil)...)
+	for i := range records {
+		dir = append(dir, records[i]...)
+	}
+	return dir
+}
+
+// susp creates a System Use Sharing Protocol entry
+func susp(signature string, data ...byte) []byte {
+	return append([]byte{signature[0], signature[1], byte(4 + len(data)), 1}, data...)
+}
+
+// nm creates a Rock Ridge alternate name entry
+func nm(flags byte, name string) []byte {
+	return susp("NM", append([]byte{flags}, name...)...)
+}
+
+// ce creates a continuation area entry
+func ce(location, offset, length uint32) []byte {
+	data := make([]byte, 24)
+	binary.LittleEndian.PutUint32(data[0:4], location)
+	binary.BigEndian.PutUint32(data[4:8], location)
+	binary.LittleEndian.PutUint32(data[8:12], offset)
+	binary.BigEndian.PutUint32(data[12:16], offset)
+	binary.LittleEndian.PutUint32(data[16:20], length)
+	binary.BigEndian.PutUint32(data[20:24], length)
+	return susp("CE", data...)
+}
+
+// ucs2 encodes a Joliet name
+func ucs2(name string) []byte {
+	chars := utf16.Encode([]rune(name))
+	b := make([]byte, len(chars)*2)
+	for i := range chars {
+		binary.BigEndian.PutUint16(b[i*2:], chars[i])
+	}
+	return b
+}
+
+// rockRidgeSP is the "SP" entry that identifies Rock Ridge in the root directory's "." record
+var rockRidgeSP = susp("SP", 0xBE, 0xEF, 0)
+
+// testUDF builds a UDF file system within an image, the partition starts after the anchor and blocks are allocated
+// from the partition
+type testUDF struct {
+	iso   *testISO
+	start uint32
+}
+
+// newTestUDF writes the anchor and the volume descriptor sequence, partitionMap is the map of the single partition
+func newTestUDF(iso *testISO, volumeID string, partitionMap []byte) *testUDF {
+	iso.alloc(udfAnchorSector + 1 - len(iso.sectors))
+	udf := &testUDF{iso: iso, start: udfAnchorSector + 1}
+
+	// The volume descriptor sequence is ahead of the anchor
+	const sequence = 32
+	anchor := make([]byte, isoSectorSize)
+	binary.LittleEndian.PutUint32(anchor[16:20], 3*isoSectorSize)
+	binary.LittleEndian.PutUint32(anchor[20:24], sequence)
+	iso.write(udfAnchorSector, 0, udfTag(anchor, udfTagAnchor, udfAnchorSector))
+
+	partition := make([]byte, isoSectorSize)
+	binary.LittleEndian.PutUint32(partition[188:192], udf.start)
+	iso.write(sequence, 0, udfTag(partition, udfTagPartition, sequence))
+
+	logicalVolume := make([]byte, isoSectorSize)
+	name := append([]byte{8}, volumeID...)
+	copy(logicalVolume[84:], name)
+	logicalVolume[211] = byte(len(name))
+	binary.LittleEndian.PutUint32(logicalVolume[212:216], isoSectorSize)
+	copy(logicalVolume[248:264], udfLongAD(isoSectorSize, 0))
+	binary.LittleEndian.PutUint32(logicalVolume[264:268], uint32(len(partitionMap)))
+	binary.LittleEndian.PutUint32(logicalVolume[268:272], 1)
+	copy(logicalVolume[440:], partitionMap)
+	iso.write(sequence+1, 0, udfTag(logicalVolume, udfTagLogicalVolume, sequence+1))
+
+	iso.write(sequence+2, 0, udfTag(make([]byte, isoSectorSize), udfTagTerminating, sequence+2))
+
+	// The file set descriptor is the first block of the partition
+	udf.alloc(1)
+	return udf
+}
+
+// udfPartitionMap is a type 1 map of partition 0
+var udfPartitionMap = []byte{1, 6, 1, 0, 0, 0}
+
+// alloc adds empty blocks to the partition, returning the first of them
+func (udf *testUDF) alloc(n int) uint32 {
+	return udf.iso.alloc(n) - udf.start
+}
+
+// write copies data into a block of the partition
+func (udf *testUDF) write(block uint32, data []byte) {
+	udf.iso.write(udf.start+block, 0, data)
+}
+
+// fileSet writes the file set descriptor with the location of the root directory
+func (udf *testUDF) fileSet(root uint32) {
+	fileSet := make([]byte, isoSectorSize)
+	copy(fileSet[400:416], udfLongAD(isoSectorSize, root))
+	udf.write(0, udfTag(fileSet, udfTagFileSet, 0))
+}
+
+// entry writes a file entry (or an extended file entry) with its allocation descriptors (or embedded data)
+func (udf *testUDF) entry(block uint32, fileType byte, size int, allocation uint16, ads []byte, extended bool) {
+	entry := make([]byte, isoSectorSize)
+	entry[27] = fileType
+	binary.LittleEndian.PutUint16(entry[34:36], allocation)
+	binary.LittleEndian.PutUint64(entry[56:64], uint64(size))
+	modTime, adStart, id := 84, 176, uint16(udfTagFileEntry)
+	if extended {
+		modTime, adStart, id = 92, 216, udfTagExtendedFileEntry
+	}
+	copy(entry[modTime:], []byte{0x3C, 0x10, 0xE5, 0x07, 6, 1, 12, 0, 0, 0, 0, 0})
+	binary.LittleEndian.PutUint32(entry[adStart-4:adStart], uint32(len(ads)))
+	copy(entry[adStart:], ads)
+	udf.write(block, udfTag(entry, id, block))
+}
+
+// udfTag completes the tag of a descriptor
+func udfTag(b []byte, id uint16, location uint32) []byte {
+	binary.LittleEndian.PutUint16(b[0:2], id)
+	binary.LittleEndian.PutUint16(b[2:4], 2)
+	binary.LittleEndian.PutUint32(b[12:16], location)
+	var checksum byte
+	for i := 0; i < 16; i++ {
+		if i != 4 {
+			checksum += b[i]
+		}
+	}
+	b[4] = checksum
+	return b
+}
+
+// udfShortAD creates a short allocation descriptor, the type of the extent is in the top bits of the length
+func udfShortAD(length, block uint32) []byte {
+	ad := make([]byte, 8)
+	binary.LittleEndian.PutUint32(ad[0:4], length)
+	binary.LittleEndian.PutUint32(ad[4:8], block)
+	return ad
+}
+
+// udfLongAD creates a long allocation descriptor in partition 0
+func udfLongAD(length, block uint32) []byte {
+	return append(udfShortAD(length, block), make([]byte, 8)...)
+}
+
+// udfFID creates a file identifier descriptor, names that aren't latin1 are stored as UCS-2
+func udfFID(name string, characteristics byte, block uint32) []byte {
+	var encoded []byte
+	for _, c := range name {
+		if c > 0xFF {
+			encoded = append([]byte{16}, ucs2(name)...)
+			break
+		}
+		encoded = append(encoded, byte(c))
+	}
+	if len(encoded) != 0 && encoded[0] != 16 {
+		encoded = append([]byte{8}, encoded...)
+	}
+
+	fid := make([]byte, (38+len(encoded)+3)&^3)
+	fid[18] = characteristics
+	fid[19] = byte(len(encoded))
+	copy(fid[20:36], udfLongAD(isoSectorSize, block))
+	copy(fid[38:], encoded)
+	return udfTag(fid, udfTagFileIdentifier, 0)
+}
+
+// udfDirectory joins the file identifiers of a directory, starting with the parent
+func udfDirectory(parent uint32, fids ...[]byte) []byte {
+	dir := udfFID("", udfCharacteristicDir|udfCharacteristicParent, parent)
+	for i := range fids {
+		dir = append(dir, fids[i]...)
+	}
+	return dir
+}
+
+// testUDFTree writes the UDF tree of a Windows image, the files cover each type of allocation descriptor
+func testUDFTree(udf *testUDF) {
+	root, rootData, sources, bootmgr, aed, wimSecond := udf.alloc(1), udf.alloc(1), udf.alloc(1), udf.alloc(1), udf.alloc(1), udf.alloc(1)
+	udf.alloc(1)
+	wimFirst, wim, unicode := udf.alloc(1), udf.alloc(1), udf.alloc(1)
+	udf.fileSet(root)
+
+	// bootmgr has a long allocation descriptor
+	bootmgrData := udf.alloc(1)
+	udf.write(bootmgrData, []byte("bootmgr"))
+	udf.entry(bootmgr, 5, 7, udfLongAllocation, udfLongAD(7, bootmgrData), false)
+
+	// boot.wim has two extents (the second before the first), the second is found through an allocation extent
+	udf.write(wimFirst, bytes.Repeat([]byte("w"), isoSectorSize))
+	udf.write(wimSecond, []byte("im"))
+	udf.entry(wim, 5, isoSectorSize+2, udfShortAllocation,
+		append(udfShortAD(isoSectorSize, wimFirst), udfShortAD(udfExtentContinuation<<30|isoSectorSize, aed)...), false)
+	extent := make([]byte, isoSectorSize)
+	binary.LittleEndian.PutUint32(extent[20:24], 8)
+	copy(extent[24:], udfShortAD(isoSectorSize, wimSecond))
+	udf.write(aed, udfTag(extent, udfTagAllocationExtent, aed))
+
+	// The UCS-2 file has its data embedded in an extended file entry
+	udf.entry(unicode, 5, 7, udfEmbeddedAllocation, []byte("unicode"), true)
+
+	// The sources directory is embedded in its extended file entry
+	sourcesDir := udfDirectory(root, udfFID("boot.wim", 0, wim), udfFID("Ünicode ☃.txt", 0, unicode))
+	udf.entry(sources, udfFileTypeDirectory, len(sourcesDir), udfEmbeddedAllocation, sourcesDir, true)
+
+	rootDir := udfDirectory(root, udfFID("bootmgr", 0, bootmgr), udfFID("sources", udfCharacteristicDir, sources),
+		udfFID("deleted", udfCharacteristicDeleted, bootmgr))
+	udf.write(rootData, rootDir)
+	udf.entry(root, udfFileTypeDirectory, len(rootDir), udfShortAllocation, udfShortAD(uint32(len(rootDir)), rootData), false)
+}
+
+// testUDFFiles are the files written by testUDFTree
+var testUDFFiles = map[string]string{
+	"/bootmgr":               "bootmgr",
+	"/sources/boot.wim":      string(bytes.Repeat([]byte("w"), isoSectorSize)) + "im",
+	"/sources/Ünicode ☃.txt": "unicode",
+}
+
+// readTestFile reads the contents of an indexed file
+func readTestFile(t *testing.T, image *isoImage, filePath string) string {
+	f, ok := image.files[filePath]
+	if !ok {
+		t.Fatalf("File [%s] wasn't indexed", filePath)
+	}
+	b := make([]byte, f.size)
+	if _, err := image.reader(f).ReadAt(b, 0); err != nil {
+		t.Fatalf("Unable to read [%s] -> %v", filePath, err)
+	}
+	return string(b)
+}
+
+func TestIndexISO(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *testISO
+		files map[string]string // the expected contents of each file
+		dirs  []string
+		short map[string]string // iso9660 names and the long name of the same file
+		label string            // the expected volume label, if it is set
+	}{
+		{
+			name: "plain iso9660 names",
+			build: func() *testISO {
+				iso := newTestISO()
+				root, data := iso.alloc(1), iso.alloc(1)
+				iso.write(data, 0, []byte("readme"))
+				iso.write(root, 0, testDirectory(root, nil, testRecord([]byte("README.TXT;1"), data, 6, 0, nil)))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "PLAIN", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				return iso
+			},
+			files: map[string]string{"/readme.txt": "readme"},
+			short: map[string]string{"/readme.txt": "/readme.txt"},
+		},
+		{
+			name: "rock ridge names continued across NM entries",
+			build: func() *testISO {
+				iso := newTestISO()
+				root, dir, data := iso.alloc(1), iso.alloc(1), iso.alloc(1)
+				iso.write(data, 0, []byte("kernel"))
+				iso.write(dir, 0, testDirectory(dir, nil,
+					testRecord([]byte("VMLINUZ.;1"), data, 6, 0, append(nm(1, "vmlinuz-"), nm(0, "5.15.0-generic")...))))
+				iso.write(root, 0, testDirectory(root, rockRidgeSP,
+					testRecord([]byte("CASPER"), dir, isoSectorSize, isoFlagDirectory, nm(0, "casper"))))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "RR", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				return iso
+			},
+			files: map[string]string{"/casper/vmlinuz-5.15.0-generic": "kernel"},
+			dirs:  []string{"/casper"},
+			short: map[string]string{"/casper/vmlinuz.": "/casper/vmlinuz-5.15.0-generic"},
+		},
+		{
+			name: "rock ridge name continued in a SUSP continuation area",
+			build: func() *testISO {
+				iso := newTestISO()
+				root, area, data := iso.alloc(1), iso.alloc(1), iso.alloc(1)
+				iso.write(data, 0, []byte("initrd"))
+				// The continuation area is part way into its sector
+				rest := nm(0, "-with-a-very-long-name.img")
+				iso.write(area, 100, rest)
+				iso.write(root, 0, testDirectory(root, rockRidgeSP,
+					testRecord([]byte("INITRD.IMG;1"), data, 6, 0, append(nm(1, "initrd"), ce(area, 100, uint32(len(rest)))...))))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "CE", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				return iso
+			},
+			files: map[string]string{"/initrd-with-a-very-long-name.img": "initrd"},
+		},
+		{
+			name: "joliet UCS-2 names",
+			build: func() *testISO {
+				iso := newTestISO()
+				root, jolietRoot, dir, jolietDir, data := iso.alloc(1), iso.alloc(1), iso.alloc(1), iso.alloc(1), iso.alloc(1)
+				iso.write(data, 0, []byte("setup"))
+				iso.write(dir, 0, testDirectory(dir, nil, testRecord([]byte("SETUP.EXE;1"), data, 5, 0, nil)))
+				iso.write(root, 0, testDirectory(root, nil, testRecord([]byte("SOURCES"), dir, isoSectorSize, isoFlagDirectory, nil)))
+				iso.write(jolietDir, 0, testDirectory(jolietDir, nil, testRecord(ucs2("Setup Ü.exe;1"), data, 5, 0, nil)))
+				iso.write(jolietRoot, 0, testDirectory(jolietRoot, nil, testRecord(ucs2("Sources"), jolietDir, isoSectorSize, isoFlagDirectory, nil)))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "JOLIET", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				// The supplementary descriptor replaces the terminator that follows the primary
+				iso.volumeDescriptor(isoDescriptorStart+1, isoDescriptorSupplementary, "", testRecord([]byte{0}, jolietRoot, isoSectorSize, isoFlagDirectory, nil))
+				return iso
+			},
+			files: map[string]string{"/Sources/Setup Ü.exe": "setup"},
+			dirs:  []string{"/Sources"},
+			short: map[string]string{"/sources/setup.exe": "/Sources/Setup Ü.exe"},
+		},
+		{
+			name: "multi-extent file with extents that aren't contiguous",
+			build: func() *testISO {
+				iso := newTestISO()
+				// The second extent comes first, and there is a gap between them
+				root, second := iso.alloc(1), iso.alloc(1)
+				iso.alloc(1)
+				first := iso.alloc(1)
+				iso.write(first, 0, bytes.Repeat([]byte("a"), isoSectorSize))
+				iso.write(second, 0, []byte("bb"))
+				iso.write(root, 0, testDirectory(root, nil,
+					testRecord([]byte("INSTALL.WIM;1"), first, isoSectorSize, isoFlagMultiExtent, nil),
+					testRecord([]byte("INSTALL.WIM;1"), second, 2, 0, nil),
+					testRecord([]byte("NEXT.TXT;1"), second, 2, 0, nil)))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "MULTI", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				return iso
+			},
+			files: map[string]string{
+				"/install.wim": string(bytes.Repeat([]byte("a"), isoSectorSize)) + "bb",
+				"/next.txt":    "bb",
+			},
+		},
+		{
+			name: "udf bridge image with a placeholder iso9660 tree",
+			build: func() *testISO {
+				iso := newTestISO()
+				root, data := iso.alloc(1), iso.alloc(1)
+				iso.write(data, 0, []byte("readme"))
+				iso.write(root, 0, testDirectory(root, nil, testRecord([]byte("README.TXT;1"), data, 6, 0, nil)))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "SSS_X64FREE_EN-US_DV9", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				testUDFTree(newTestUDF(iso, "UDF VOLUME", udfPartitionMap))
+				return iso
+			},
+			files: testUDFFiles,
+			dirs:  []string{"/sources"},
+			label: "SSS_X64FREE_EN-US_DV9",
+		},
+		{
+			name: "udf only image",
+			build: func() *testISO {
+				iso := newTestISO()
+				// The volume recognition sequence replaces the iso9660 volume descriptors
+				for i, id := range []string{"BEA01", "NSR02", "TEA01"} {
+					iso.write(uint32(isoDescriptorStart+i), 1, []byte(id))
+				}
+				testUDFTree(newTestUDF(iso, "UDF VOLUME", udfPartitionMap))
+				return iso
+			},
+			files: testUDFFiles,
+			dirs:  []string{"/sources"},
+			label: "UDF VOLUME",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image, err := indexISO(test.build().file(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer image.file.Close()
+
+			if len(image.files) != len(test.files)+len(test.dirs) {
+				t.Errorf("Indexed [%d] files, expected [%d] -> %v", len(image.files), len(test.files)+len(test.dirs), image.files)
+			}
+			for filePath, content := range test.files {
+				if got := readTestFile(t, image, filePath); got != content {
+					t.Errorf("File [%s] is [%.20q], expected [%.20q]", filePath, got, content)
+				}
+			}
+			for _, dirPath := range test.dirs {
+				if f, ok := image.files[dirPath]; !ok || !f.dir {
+					t.Errorf("Directory [%s] wasn't indexed", dirPath)
+				}
+			}
+			if test.label != "" && image.volumeID != test.label {
+				t.Errorf("Volume label is [%s], expected [%s]", image.volumeID, test.label)
+			}
+			for shortPath, longPath := range test.short {
+				short, long := image.isoNames[shortPath], image.files[longPath]
+				if short == nil || long == nil || short.offset != long.offset || short.size != long.size {
+					t.Errorf("iso9660 name [%s] doesn't refer to [%s]", shortPath, longPath)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexISOErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *testISO
+		err   string
+	}{
+		{
+			name: "continuation areas that loop",
+			build: func() *testISO {
+				iso := newTestISO()
+				root, area := iso.alloc(1), iso.alloc(1)
+				loop := ce(area, 0, 28)
+				iso.write(area, 0, loop)
+				iso.write(root, 0, testDirectory(root, rockRidgeSP, testRecord([]byte("LOOP;1"), area, 0, 0, loop)))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "LOOP", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				return iso
+			},
+			err: "Too many Rock Ridge continuation areas",
+		},
+		{
+			name: "directory record with a name longer than the record",
+			build: func() *testISO {
+				iso := newTestISO()
+				root := iso.alloc(1)
+				record := testRecord([]byte("BROKEN;1"), root, 0, 0, nil)
+				record[32] = 200
+				iso.write(root, 0, testDirectory(root, nil, record))
+				iso.volumeDescriptor(isoDescriptorStart, isoDescriptorPrimary, "BROKEN", testRecord([]byte{0}, root, isoSectorSize, isoFlagDirectory, nil))
+				return iso
+			},
+			err: "Directory record name is corrupt",
+		},
+		{
+			name: "udf metadata partition",
+			build: func() *testISO {
+				iso := newTestISO()
+				metadataMap := append([]byte{2, 64}, make([]byte, 62)...)
+				testUDFTree(newTestUDF(iso, "UDF VOLUME", metadataMap))
+				return iso
+			},
+			err: "Partition map of type [2] isn't supported",
+		},
+		{
+			name: "no file system",
+			build: func() *testISO {
+				return newTestISO()
+			},
+			err: "No iso9660 or UDF file system found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image, err := indexISO(test.build().file(t))
+			if err == nil {
+				image.file.Close()
+				t.Fatal("Expected the image to be rejected")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Error is [%v], expected [%s]", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"time"
+	"unicode/utf16"
+)
+
+// UDF (ECMA-167) is the file system of DVD media, current Windows media are UDF bridge images where the iso9660 tree
+// only holds a README.TXT and all of the files are in the UDF tree. Only the structures used by read-only media are
+// supported (type 1 partition maps and short, long or embedded allocation descriptors), anything else is rejected
+// rather than serving a partial tree.
+
+const (
+	// udfAnchorSector is the sector of the anchor volume descriptor pointer
+	udfAnchorSector = 256
+
+	udfTagAnchor             = 2
+	udfTagPartition          = 5
+	udfTagLogicalVolume      = 6
+	udfTagTerminating        = 8
+	udfTagFileSet            = 256
+	udfTagFileIdentifier     = 257
+	udfTagAllocationExtent   = 258
+	udfTagFileEntry          = 261
+	udfTagExtendedFileEntry  = 266
+	udfFileTypeDirectory     = 4
+	udfCharacteristicDir     = 0x02
+	udfCharacteristicDeleted = 0x04
+	udfCharacteristicParent  = 0x08
+
+	// The allocation descriptor types (the lowest bits of the ICB flags)
+	udfShortAllocation    = 0
+	udfLongAllocation     = 1
+	udfEmbeddedAllocation = 3
+
+	// udfExtentContinuation is the type of an extent that holds the next allocation descriptors
+	udfExtentContinuation = 3
+
+	// udfMaxDescriptors limits the length of the volume descriptor sequence and the allocation descriptor chains
+	udfMaxDescriptors = 256
+)
+
+// udfVolume is a UDF logical volume, the partitions are indexed by their partition reference number (their position in
+// the partition maps) and hold the offset of the partition within the image
+type udfVolume struct {
+	image      *isoImage
+	blockSize  int64
+	partitions []int64
+}
+
+// udfLocation is the location of a block within a partition (a long_ad)
+type udfLocation struct {
+	block     uint32
+	partition uint16
+}
+
+// udfEntry is a parsed file entry
+type udfEntry struct {
+	dir     bool
+	size    int64
+	modTime time.Time
+	extents []isoExtent
+}
+
+// indexUDF will replace the files with the UDF tree if the image has a UDF file system, returning false if it doesn't
+func (image *isoImage) indexUDF() (bool, error) {
+	anchor := make([]byte, isoSectorSize)
+	if _, err := image.file.ReadAt(anchor, udfAnchorSector*isoSectorSize); err != nil || !validUDFTag(anchor, udfTagAnchor) {
+		return false, nil
+	}
+
+	volume, root, err := image.readUDFVolume(binary.LittleEndian.Uint32(anchor[20:24]), binary.LittleEndian.Uint32(anchor[16:20]))
+	if err != nil {
+		return true, fmt.Errorf("Unable to read UDF file system -> %v", err)
+	}
+
+	image.files = make(map[string]*isoFile)
+	if err = volume.walk(root, "", 0, map[int64]bool{}); err != nil {
+		return true, fmt.Errorf("Unable to read UDF file system -> %v", err)
+	}
+	return true, nil
+}
+
+// readUDFVolume reads the volume descriptor sequence, returning the logical volume and the location of the root
+// directory from its file set descriptor
+func (image *isoImage) readUDFVolume(location, length uint32) (*udfVolume, udfLocation, error) {
+	var root udfLocation
+	partitionStarts := make(map[uint16]int64)
+	var logicalVolume []byte
+
+	buf := make([]byte, isoSectorSize)
+	for i := uint32(0); i < length/isoSectorSize && i < udfMaxDescriptors; i++ {
+		if _, err := image.file.ReadAt(buf, int64(location+i)*isoSectorSize); err != nil {
+			return nil, root, fmt.Errorf("Unable to read volume descriptor -> %v", err)
+		}
+		if validUDFTag(buf, udfTagTerminating) {
+			break
+		}
+		switch {
+		case validUDFTag(buf, udfTagPartition):
+			partitionStarts[binary.LittleEndian.Uint16(buf[22:24])] = int64(binary.LittleEndian.Uint32(buf[188:192]))
+		case validUDFTag(buf, udfTagLogicalVolume) && logicalVolume == nil:
+			logicalVolume = append([]byte{}, buf...)
+		}
+	}
+	if logicalVolume == nil {
+		return nil, root, fmt.Errorf("Logical volume descriptor not found")
+	}
+
+	volume := &udfVolume{image: image, blockSize: int64(binary.LittleEndian.Uint32(logicalVolume[212:216]))}
+	if volume.blockSize != isoSectorSize {
+		return nil, root, fmt.Errorf("Block size [%d] isn't supported", volume.blockSize)
+	}
+	if image.volumeID == "" {
+		image.volumeID = decodeUDFDString(logicalVolume[84:212])
+	}
+
+	// The partition maps follow the descriptor, each map refers to a partition descriptor by its number
+	maps := logicalVolume[440:]
+	mapsLength := int(binary.LittleEndian.Uint32(logicalVolume[264:268]))
+	if mapsLength > len(maps) {
+		return nil, root, fmt.Errorf("Partition maps are corrupt")
+	}
+	maps = maps[:mapsLength]
+	for count := binary.LittleEndian.Uint32(logicalVolume[268:272]); count > 0; count-- {
+		if len(maps) < 2 || int(maps[1]) < 2 || int(maps[1]) > len(maps) {
+			return nil, root, fmt.Errorf("Partition maps are corrupt")
+		}
+		if maps[0] != 1 || maps[1] != 6 {
+			// Type 2 maps are virtual, sparable or metadata partitions (e.g. UDF 2.50 and later)
+			return nil, root, fmt.Errorf("Partition map of type [%d] isn't supported", maps[0])
+		}
+		start, ok := partitionStarts[binary.LittleEndian.Uint16(maps[4:6])]
+		if !ok {
+			return nil, root, fmt.Errorf("Partition [%d] not found", binary.LittleEndian.Uint16(maps[4:6]))
+		}
+		volume.partitions = append(volume.partitions, start*volume.blockSize)
+		maps = maps[maps[1]:]
+	}
+
+	// The logical volume contents use holds the location of the file set descriptor
+	fileSet, err := volume.readBlock(parseUDFLocation(logicalVolume[248:264]))
+	if err != nil {
+		return nil, root, err
+	}
+	if !validUDFTag(fileSet, udfTagFileSet) {
+		return nil, root, fmt.Errorf("File set descriptor not found")
+	}
+	return volume, parseUDFLocation(fileSet[400:416]), nil
+}
+
+// walk will read a directory and add all of its entries to the index, the visited directories are tracked by the
+// offset of their file entry to prevent loops
+func (volume *udfVolume) walk(location udfLocation, dirPath string, depth int, visited map[int64]bool) error {
+	if depth > isoMaxDepth {
+		return fmt.Errorf("Directory tree is deeper than %d levels", isoMaxDepth)
+	}
+	offset, err := volume.offset(location)
+	if err != nil {
+		return err
+	}
+	if visited[offset] {
+		return nil
+	}
+	visited[offset] = true
+
+	dir, err := volume.readEntry(location)
+	if err != nil {
+		return err
+	}
+	if dir.size > isoMaxDirectorySize {
+		return fmt.Errorf("Directory [%s] is too large [%d bytes]", dirPath, dir.size)
+	}
+	buf := make([]byte, dir.size)
+	if _, err = (isoExtentReader{file: volume.image.file, extents: dir.extents}).ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("Unable to read directory [%s] -> %v", dirPath, err)
+	}
+
+	for len(buf) != 0 {
+		if len(buf) < 38 || !validUDFTag(buf, udfTagFileIdentifier) {
+			return fmt.Errorf("File identifier in directory [%s] is corrupt", dirPath)
+		}
+		characteristics := buf[18]
+		nameLength := int(buf[19])
+		implementationLength := int(binary.LittleEndian.Uint16(buf[36:38]))
+		length := (38 + implementationLength + nameLength + 3) &^ 3
+		if 38+implementationLength+nameLength > len(buf) {
+			return fmt.Errorf("File identifier in directory [%s] is corrupt", dirPath)
+		}
+		name := decodeUDFName(buf[38+implementationLength : 38+implementationLength+nameLength])
+		childLocation := parseUDFLocation(buf[20:36])
+		if length > len(buf) {
+			length = len(buf)
+		}
+		buf = buf[length:]
+
+		if characteristics&(udfCharacteristicDeleted|udfCharacteristicParent) != 0 || name == "" {
+			continue
+		}
+
+		entry, err := volume.readEntry(childLocation)
+		if err != nil {
+			return err
+		}
+		f := &isoFile{
+			name:    name,
+			size:    entry.size,
+			modTime: entry.modTime,
+			dir:     entry.dir || characteristics&udfCharacteristicDir != 0,
+		}
+		if len(entry.extents) != 0 {
+			f.offset = entry.extents[0].offset
+		}
+		if len(entry.extents) > 1 {
+			f.extents = entry.extents
+		}
+
+		filePath := path.Join("/", dirPath, name)
+		volume.image.files[filePath] = f
+		if f.dir {
+			if err = volume.walk(childLocation, filePath, depth+1, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readEntry reads a file entry (or extended file entry), following its allocation descriptors to find the extents
+// that hold its data
+func (volume *udfVolume) readEntry(location udfLocation) (*udfEntry, error) {
+	offset, err := volume.offset(location)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := volume.readBlock(location)
+	if err != nil {
+		return nil, err
+	}
+
+	var modTime []byte
+	var adStart int
+	switch {
+	case validUDFTag(buf, udfTagFileEntry):
+		modTime, adStart = buf[84:96], 176
+	case validUDFTag(buf, udfTagExtendedFileEntry):
+		modTime, adStart = buf[92:104], 216
+	default:
+		return nil, fmt.Errorf("File entry at block [%d] is corrupt", location.block)
+	}
+	eaLength := int(binary.LittleEndian.Uint32(buf[adStart-8 : adStart-4]))
+	adLength := int(binary.LittleEndian.Uint32(buf[adStart-4 : adStart]))
+	adStart += eaLength
+	if adStart+adLength > len(buf) {
+		return nil, fmt.Errorf("File entry at block [%d] is corrupt", location.block)
+	}
+
+	entry := &udfEntry{
+		dir:     buf[27] == udfFileTypeDirectory,
+		size:    int64(binary.LittleEndian.Uint64(buf[56:64])),
+		modTime: udfTimestamp(modTime, volume.image.modTime),
+	}
+
+	allocation := binary.LittleEndian.Uint16(buf[34:36]) & 0x07
+	if allocation == udfEmbeddedAllocation {
+		// The data is held in the file entry itself
+		if entry.size > int64(adLength) {
+			return nil, fmt.Errorf("File entry at block [%d] is corrupt", location.block)
+		}
+		entry.extents = []isoExtent{{offset: offset + int64(adStart), size: entry.size}}
+		return entry, nil
+	}
+
+	entry.extents, err = volume.readAllocation(buf[adStart:adStart+adLength], allocation, location.partition)
+	if err != nil {
+		return nil, fmt.Errorf("File entry at block [%d] -> %v", location.block, err)
+	}
+
+	// The extents may be rounded up to a whole block, only the information length is part of the file
+	remaining := entry.size
+	for i := range entry.extents {
+		if entry.extents[i].size > remaining {
+			entry.extents[i].size = remaining
+		}
+		remaining -= entry.extents[i].size
+	}
+	if remaining != 0 {
+		return nil, fmt.Errorf("File entry at block [%d] has [%d bytes] that aren't allocated", location.block, remaining)
+	}
+	return entry, nil
+}
+
+// readAllocation parses a list of allocation descriptors, following the allocation extent descriptors that continue
+// the list
+func (volume *udfVolume) readAllocation(ads []byte, allocation uint16, partition uint16) ([]isoExtent, error) {
+	adSize := 8
+	switch allocation {
+	case udfShortAllocation:
+	case udfLongAllocation:
+		adSize = 16
+	default:
+		return nil, fmt.Errorf("Allocation descriptors of type [%d] aren't supported", allocation)
+	}
+
+	var extents []isoExtent
+	for continuations := 0; len(ads) >= adSize; {
+		length := binary.LittleEndian.Uint32(ads[0:4])
+		location := udfLocation{block: binary.LittleEndian.Uint32(ads[4:8]), partition: partition}
+		if allocation == udfLongAllocation {
+			location.partition = binary.LittleEndian.Uint16(ads[8:10])
+		}
+		ads = ads[adSize:]
+
+		size := int64(length & 0x3FFFFFFF)
+		if size == 0 {
+			break
+		}
+		switch length >> 30 {
+		case 0:
+			offset, err := volume.offset(location)
+			if err != nil {
+				return nil, err
+			}
+			extents = append(extents, isoExtent{offset: offset, size: size})
+		case udfExtentContinuation:
+			continuations++
+			if continuations > udfMaxDescriptors {
+				return nil, fmt.Errorf("Too many allocation extents")
+			}
+			buf, err := volume.readBlock(location)
+			if err != nil {
+				return nil, err
+			}
+			if !validUDFTag(buf, udfTagAllocationExtent) {
+				return nil, fmt.Errorf("Allocation extent at block [%d] is corrupt", location.block)
+			}
+			next := int(binary.LittleEndian.Uint32(buf[20:24]))
+			if 24+next > len(buf) {
+				return nil, fmt.Errorf("Allocation extent at block [%d] is corrupt", location.block)
+			}
+			ads = buf[24 : 24+next]
+		default:
+			// Unrecorded extents aren't used by read-only media
+			return nil, fmt.Errorf("Unrecorded extents aren't supported")
+		}
+	}
+	return extents, nil
+}
+
+// offset returns the offset of a block within the image
+func (volume *udfVolume) offset(location udfLocation) (int64, error) {
+	if int(location.partition) >= len(volume.partitions) {
+		return 0, fmt.Errorf("Partition reference [%d] not found", location.partition)
+	}
+	return volume.partitions[location.partition] + int64(location.block)*volume.blockSize, nil
+}
+
+// readBlock reads a single block from a partition
+func (volume *udfVolume) readBlock(location udfLocation) ([]byte, error) {
+	offset, err := volume.offset(location)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, volume.blockSize)
+	n, err := volume.image.file.ReadAt(buf, offset)
+	if err != nil && !(err == io.EOF && n == len(buf)) {
+		return nil, fmt.Errorf("Unable to read block [%d] -> %v", location.block, err)
+	}
+	return buf, nil
+}
+
+// parseUDFLocation parses the location from a long allocation descriptor
+func parseUDFLocation(b []byte) udfLocation {
+	return udfLocation{block: binary.LittleEndian.Uint32(b[4:8]), partition: binary.LittleEndian.Uint16(b[8:10])}
+}
+
+// validUDFTag checks the identifier and checksum of a descriptor tag
+func validUDFTag(b []byte, id uint16) bool {
+	if len(b) < 16 || binary.LittleEndian.Uint16(b[0:2]) != id {
+		return false
+	}
+	var checksum byte
+	for i := 0; i < 16; i++ {
+		if i != 4 {
+			checksum += b[i]
+		}
+	}
+	return checksum == b[4]
+}
+
+// decodeUDFName converts a name, the first byte is the size of each character (8 or 16 bits)
+func decodeUDFName(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	switch b[0] {
+	case 8, 254:
+		chars := make([]rune, len(b)-1)
+		for i := range chars {
+			chars[i] = rune(b[i+1])
+		}
+		return string(chars)
+	case 16, 255:
+		chars := make([]uint16, (len(b)-1)/2)
+		for i := range chars {
+			chars[i] = binary.BigEndian.Uint16(b[1+i*2:])
+		}
+		return string(utf16.Decode(chars))
+	}
+	return ""
+}
+
+// decodeUDFDString converts a fixed length field, the last byte is the length of the name
+func decodeUDFDString(b []byte) string {
+	length := int(b[len(b)-1])
+	if length == 0 || length > len(b)-1 {
+		return ""
+	}
+	return decodeUDFName(b[:length])
+}
+
+// udfTimestamp converts a timestamp, if it isn't set then the fallback time is returned
+func udfTimestamp(b []byte, fallback time.Time) time.Time {
+	year := int(int16(binary.LittleEndian.Uint16(b[2:4])))
+	if year == 0 {
+		return fallback
+	}
+	zone := time.UTC
+	// The offset from UTC in minutes is a signed 12 bit value, -2047 means that it isn't specified
+	if offset := int(int16(binary.LittleEndian.Uint16(b[0:2])<<4) >> 4); offset != -2047 {
+		zone = time.FixedZone("", offset*60)
+	}
+	microseconds := int(b[9])*10000 + int(b[10])*100 + int(b[11])
+	return time.Date(year, time.Month(b[4]), int(b[5]), int(b[6]), int(b[7]), int(b[8]), microseconds*1000, zone)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"net"
+	"strings"
+
+	dhcp "github.com/krolaw/dhcp4"
+	log "github.com/sirupsen/logrus"
+)
+
+// pxeVendorClass is the vendor class identifier (option 60) that PXE clients and a proxyDHCP server use
+const pxeVendorClass = "PXEClient"
+
+// pxeDiscoveryControl is the PXE vendor option (option 43) that tells the client to skip boot server discovery and
+// just use the boot filename that has been returned (sub-option 6, length 1, value 8, followed by the end marker)
+var pxeDiscoveryControl = []byte{6, 1, 8, 255}
+
+// proxyBootServer handles the requests that PXE clients send directly to the proxyDHCP server on port 4011
+type proxyBootServer struct {
+	*DHCPSettings
+}
+
+// ServeDHCP - Is the function that is called when plunder receives DHCP packets on the boot server port
+func (b proxyBootServer) ServeDHCP(p dhcp.Packet, msgType dhcp.MessageType, options dhcp.Options) dhcp.Packet {
+	return b.serveProxyDHCP(p, msgType, options, true)
+}
+
+// serveProxyDHCP - Is the function that handles DHCP packets when plunder is running alongside an existing DHCP server.
+// Only PXE clients that have a deployment are answered, and the replies contain no address (the existing DHCP server
+// remains responsible for that) only the next-server and boot filename. On port 67 only the requests that identify
+// this server are answered, any others are accepting the lease of the existing DHCP server.
+func (h *DHCPSettings) serveProxyDHCP(p dhcp.Packet, msgType dhcp.MessageType, options dhcp.Options, bootServer bool) dhcp.Packet {
+	// Ignore anything that isn't a PXE client
+	if !strings.HasPrefix(string(options[dhcp.OptionVendorClassIdentifier]), pxeVendorClass) {
+		return nil
+	}
+
+	mac := strings.ToLower(p.CHAddr().String())
+	log.Debugf("ProxyDHCP Message Type: [%v] from MAC Address [%s]", msgType, mac)
+
+	var replyType dhcp.MessageType
+	switch msgType {
+	case dhcp.Discover:
+		replyType = dhcp.Offer
+	case dhcp.Request:
+		if server := options[dhcp.OptionServerIdentifier]; !bootServer && !net.IP(server).Equal(h.IP) {
+			return nil // Message not for this dhcp server
+		}
+		replyType = dhcp.ACK
+	default:
+		return nil
+	}
+
+	// Unknown servers may be automatically enrolled if they match a rule
+	if msgType == dhcp.Discover {
+		publishEvent(EventDHCPDiscover, mac, "", "")
+		if findDeploymentFromMac(mac) == nil {
+			h.enrolServer(mac, options)
+		}
+	}
+
+	// Only hosts with a deployment are provided with boot information
+	deployment := findDeploymentFromMac(mac)
+	if deployment == nil {
+		if msgType == dhcp.Discover {
+			// Keep track of the unknown host so that it can be found through the API
+			h.leaseHander("", mac)
+		}
+		return nil
+	}
+
+	// if DHCP option "OptionUserClass" is set to iPXE then we know that it's default booted to the correct bootloader
+	bootFileName := h.bootloaderFileName(options)
+	if string(options[dhcp.OptionUserClass]) == "iPXE" {
+		bootFileName = h.bootFileURL(strings.Replace(mac, ":", "-", -1), deployment.ConfigName)
+		if msgType == dhcp.Request {
+			log.Infof("Mac address [%s] is assigned a [%s] deployment type", mac, deployment.ConfigName)
+		}
+	}
+
+	replyOptions := dhcp.Options{
+		dhcp.OptionVendorClassIdentifier:     []byte(pxeVendorClass),
+		dhcp.OptionVendorSpecificInformation: pxeDiscoveryControl,
+		dhcp.OptionBootFileName:              []byte(bootFileName),
+	}
+
+	if replyType == dhcp.Offer {
+		publishEvent(EventDHCPOffer, mac, "", deployment.ConfigName)
+	} else {
+		publishEvent(EventDHCPAck, mac, p.CIAddr().String(), deployment.ConfigName)
+	}
+
+	// No address or lease time is returned from a proxyDHCP server
+	reply := dhcp.ReplyPacket(p, replyType, h.IP, nil, 0, replyOptions.SelectOrderOrAll(nil))
+	reply.SetSIAddr(h.NextServer)
+	reply.SetFile([]byte(bootFileName))
+	return reply
+}
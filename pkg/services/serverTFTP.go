@@ -1,51 +1,178 @@
 package services
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"plunder-app/plunder/pkg/tftp"
 
 	log "github.com/sirupsen/logrus"
-	tftp "github.com/thebsdbox/go-tftp/server"
+)
+
+// defaultPXEFileName is the name of the embedded iPXE bootloader
+const defaultPXEFileName = "undionly.kpxe"
+
+// defaultPXEEFIFileName / defaultPXEARM64FileName are the UEFI bootloaders used when none are configured
+const (
+	defaultPXEEFIFileName   = "ipxe.efi"
+	defaultPXEARM64FileName = "snp.efi"
 )
 
 var iPXEData []byte
 
+// iPXEFileName is the filename that the iPXE bootloader (iPXEData) is served as
+var iPXEFileName string
+
+// tftpFiles contains the additional (UEFI) bootloaders, indexed by the filename that is handed out through DHCP
+var tftpFiles map[string][]byte
+
+// tftpRoot is the directory that files are served from, if blank then only the bootloaders are served
+var tftpRoot string
+
 // HandleWrite : writing is disabled in this service
 func HandleWrite(filename string) (w io.Writer, err error) {
 	err = errors.New("Server is read only")
 	return
 }
 
-// HandleRead : read a ROfs file and send over tftp
+// HandleRead : read a file from the TFTP root (or one of the cached bootloaders) and send over tftp
 func HandleRead(filename string) (r io.Reader, err error) {
-	r = bytes.NewBuffer(iPXEData)
-	return
+	name := tftpCleanPath(filename)
+
+	// Files in the TFTP root take precedence over the cached bootloaders
+	if tftpRoot != "" {
+		f, err := openTFTPRoot(tftpRoot, name)
+		if err == nil {
+			log.Debugf("TFTP serving [%s] from [%s]", name, tftpRoot)
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			log.Warnf("TFTP unable to serve [%s] -> %v", filename, err)
+			return nil, err
+		}
+	}
+
+	if b, ok := tftpFiles[name]; ok {
+		return bytes.NewReader(b), nil
+	}
+
+	// The embedded bootloader is only ever served as the default bootloader name
+	if name == iPXEFileName {
+		return bytes.NewReader(iPXEData), nil
+	}
+
+	log.Warnf("TFTP request for [%s], file not found", filename)
+	return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+}
+
+// tftpCleanPath will convert a requested filename into a clean relative path, any attempt to traverse above the root
+// (e.g. ../../etc/passwd) is removed
+func tftpCleanPath(filename string) string {
+	// Some clients will request files with windows style paths
+	filename = strings.Replace(filename, "\\", "/", -1)
+	return strings.TrimLeft(path.Clean("/"+filename), "/")
+}
+
+// openTFTPRoot will open a file from the TFTP root, ensuring that the file (once any symlinks are followed) is still
+// inside of the root directory
+func openTFTPRoot(root, name string) (*os.File, error) {
+	if name == "" {
+		return nil, os.ErrNotExist
+	}
+
+	rootPath, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	rootPath, err = filepath.Abs(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	filePath, err := filepath.EvalSymlinks(filepath.Join(rootPath, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	filePath, err = filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(filePath, rootPath+string(filepath.Separator)) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filePath)
+}
+
+// bootloaderPath will return the path to a bootloader from the configuration, or the default if one isn't set
+func bootloaderPath(path *string, defaultPath string) string {
+	if path == nil || *path == "" {
+		return defaultPath
+	}
+	return *path
 }
 
 // tftp server
 func (c *BootController) serveTFTP() error {
+	iPXEFileName = defaultPXEFileName
+	if c.PXEFileName != nil && *c.PXEFileName != "" {
+		iPXEFileName = tftpCleanPath(*c.PXEFileName)
+	}
 
-	log.Printf("Opening and caching undionly.kpxe")
-	f, err := os.Open(*c.PXEFileName)
-	if err != nil {
-		log.Warnf("No local undionly.kpxe found, falling back to embedded version which may be out of date")
-		iPXEData, err = hex.DecodeString(pxeFile)
-	} else {
-		// Use bufio.NewReader to get a Reader.
-		// ... Then use ioutil.ReadAll to read the entire content.
-		r := bufio.NewReader(f)
+	if c.TFTPRoot != nil && *c.TFTPRoot != "" {
+		info, err := os.Stat(*c.TFTPRoot)
+		if err != nil {
+			return fmt.Errorf("Unable to use TFTP root [%s] -> %v", *c.TFTPRoot, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("Unable to use TFTP root [%s], it isn't a directory", *c.TFTPRoot)
+		}
+		tftpRoot = *c.TFTPRoot
+		log.Infof("Serving TFTP files from [%s]", tftpRoot)
+	}
 
-		iPXEData, err = ioutil.ReadAll(r)
+	log.Printf("Opening and caching %s", iPXEFileName)
+	var err error
+	if c.PXEFileName != nil && *c.PXEFileName != "" {
+		iPXEData, err = ioutil.ReadFile(*c.PXEFileName)
+	}
+	if len(iPXEData) == 0 || err != nil {
+		log.Warnf("No local %s found, falling back to embedded version which may be out of date", iPXEFileName)
+		iPXEData, err = hex.DecodeString(pxeFile)
 		if err != nil {
 			return err
 		}
 	}
-	s := tftp.NewServer("", HandleRead, HandleWrite)
+
+	// Cache the UEFI bootloaders, these have no embedded version to fall back to
+	tftpFiles = make(map[string][]byte)
+	for _, bootloader := range []string{bootloaderPath(c.PXEEFIFileName, defaultPXEEFIFileName), bootloaderPath(c.PXEARM64FileName, defaultPXEARM64FileName)} {
+		log.Printf("Opening and caching %s", bootloader)
+		b, err := ioutil.ReadFile(bootloader)
+		if err != nil {
+			log.Warnf("No local %s found, UEFI clients that require it will be unable to boot", bootloader)
+			continue
+		}
+		tftpFiles[tftpCleanPath(bootloader)] = b
+	}
+
+	s := tftp.NewServer(HandleRead, HandleWrite)
 	err = s.Serve(*c.TFTPAddress + ":69")
 	if err != nil {
 		return err
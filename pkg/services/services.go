@@ -1,6 +1,7 @@
 package services
 
 import (
+	"io"
 	"net/http"
 	"time"
 
@@ -65,10 +66,50 @@ func (c *BootController) setBootConfig(configName, configType, kernel, initrd, c
 	c.BootConfigs = append(c.BootConfigs, *newConfig)
 }
 
+// configureDHCPPool - This will configure the DHCP server with the pools of addresses and options that are handed to clients
+func (c *BootController) configureDHCPPool() {
+	// Additional DHCP options
+	c.handler.LeaseDuration = 2 * time.Hour //TODO, make time modifiable
+
+	// The local scope is always the first, followed by any that are reached through a relay
+	localScope := c.DHCPConfig.dhcpScope
+	if localScope.ScopeName == "" {
+		localScope.ScopeName = "local"
+	}
+
+	for _, config := range append([]dhcpScope{localScope}, c.DHCPConfig.DHCPScopes...) {
+		scope, err := newDHCPScope(config)
+		if err != nil {
+			log.Fatalf("DHCP Scope [%s] -> %v", config.ScopeName, err)
+		}
+		log.Debugf("DHCP Scope [%s] serving [%s] from [%s] with [%d] leases", scope.Name, scope.network.String(), scope.Start.String(), scope.LeaseRange)
+		c.handler.Scopes = append(c.handler.Scopes, scope)
+	}
+
+	// Restore any leases that were handed out before a restart
+	c.handler.LeaseFile = c.DHCPConfig.DHCPLeaseFile
+	err := c.handler.loadLeases()
+	if err != nil {
+		// Don't quit on error, the server will continue with an empty set of leases
+		log.Errorf("DHCP Leases -> %v", err)
+	}
+}
+
 // StartServices - This will start all of the enabled services
 func (c *BootController) StartServices(deployment []byte) error {
 	log.Infof("Starting Remote Boot Services, press CTRL + c to stop")
 
+	// Restore the deployments that were installed before a restart, so that they aren't provisioned again
+	var installed string
+	if c.InstalledFile != nil {
+		installed = *c.InstalledFile
+	}
+	err := loadInstalled(installed)
+	if err != nil {
+		// Don't quit on error, any installed servers will be provisioned again
+		log.Errorf("Installed Deployments -> %v", err)
+	}
+
 	if *c.EnableDHCP == true {
 		c.handler = &DHCPSettings{}
 		// DHCP Server address
@@ -78,46 +119,28 @@ func (c *BootController) StartServices(deployment []byte) error {
 		}
 		c.handler.IP = ip
 
-		// Start address of DHCP Range
-		ip, err = utils.ConvertIP(c.DHCPConfig.DHCPStartAddress)
-		if err != nil {
-			log.Fatalf("DHCP Start Address -> %v", err)
-		}
-		c.handler.Start = ip
-
-		// Additional DHCP options
-		c.handler.LeaseDuration = 2 * time.Hour //TODO, make time modifiable
-		c.handler.LeaseRange = c.DHCPConfig.DHCPLeasePool
-		// Initialise the two maps
-		c.handler.Leases = make(map[int]Lease, c.DHCPConfig.DHCPLeasePool)
-
-		var options = dhcp.Options{}
-
-		// Subnet
-		ip, err = utils.ConvertIP(c.DHCPConfig.DHCPSubnet)
-		if err != nil {
-			log.Fatalf("DHCP Subnet -> %v", err)
-		}
-		options[dhcp.OptionSubnetMask] = ip
-
-		// Gateway / Router
-		ip, err = utils.ConvertIP(c.DHCPConfig.DHCPGateway)
-		if err != nil {
-			log.Fatalf("DHCP Gateway -> %v", err)
+		// The next-server is where PXE clients will retrieve their bootloader from
+		c.handler.NextServer = ip
+		if c.TFTPAddress != nil && *c.TFTPAddress != "" {
+			ip, err = utils.ConvertIP(*c.TFTPAddress)
+			if err != nil {
+				log.Fatalf("TFTP Server -> %v", err)
+			}
+			c.handler.NextServer = ip
 		}
-		options[dhcp.OptionRouter] = ip
 
-		// DNS
-		ip, err = utils.ConvertIP(c.DHCPConfig.DHCPDNS)
-		if err != nil {
-			log.Fatalf("DHCP DNS ->%v", err)
+		// Bootloaders that are handed to clients based upon their architecture
+		c.handler.BIOSFileName = bootloaderPath(c.PXEFileName, defaultPXEFileName)
+		c.handler.EFIFileName = bootloaderPath(c.PXEEFIFileName, defaultPXEEFIFileName)
+		c.handler.ARM64FileName = bootloaderPath(c.PXEARM64FileName, defaultPXEARM64FileName)
+
+		if c.ProxyDHCP != nil && *c.ProxyDHCP == true {
+			// In proxyDHCP mode addresses are handed out by another DHCP server, so no pool is configured
+			c.handler.Proxy = true
+			log.Infof("DHCP Server is running in proxyDHCP mode, no addresses will be allocated")
+		} else {
+			c.configureDHCPPool()
 		}
-		options[dhcp.OptionDomainNameServer] = ip
-
-		// Set bootname path (used by tftp)
-		options[dhcp.OptionBootFileName] = []byte(*c.PXEFileName)
-
-		c.handler.Options = options
 
 		log.Debugf("\nServer IP:\t%s\nAdapter:\t%s\nStart Address:\t%s\nPool Size:\t%d\n", c.DHCPConfig.DHCPAddress, *c.AdapterName, c.DHCPConfig.DHCPStartAddress, c.DHCPConfig.DHCPLeasePool)
 		log.Println("Plunder Services --> Starting DHCP")
@@ -136,12 +159,30 @@ func (c *BootController) StartServices(deployment []byte) error {
 
 			}()
 
+			// PXE clients will send their boot server requests directly to a proxyDHCP server on port 4011
+			var proxyConnection io.Closer
+			if c.handler.Proxy {
+				bootConnection, err := dhcp_con.NewUDP4FilterListener(*c.AdapterName, ":4011")
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				proxyConnection = bootConnection
+				go func() {
+					//Close the connection when we're tidying up
+					defer bootConnection.Close()
+					dhcpError <- dhcp.Serve(bootConnection, proxyBootServer{c.handler})
+				}()
+			}
+
 			go func() {
 				select {
-				case <-dhcpError:
-					log.Infof("%s\n", dhcpError)
+				case err := <-dhcpError:
+					log.Infof("%v\n", err)
 				case <-dhcpServer:
 					newConnection.Close()
+					if proxyConnection != nil {
+						proxyConnection.Close()
+					}
 				}
 			}()
 		}
@@ -157,7 +198,7 @@ func (c *BootController) StartServices(deployment []byte) error {
 	if *c.EnableTFTP == true {
 		go func() {
 			log.Println("Plunder Services --> Starting TFTP")
-			log.Debugf("\nServer IP:\t%s\nPXEFile:\t%s\n", *c.TFTPAddress, *c.PXEFileName)
+			log.Debugf("\nServer IP:\t%s\nPXEFile:\t%s\n", *c.TFTPAddress, bootloaderPath(c.PXEFileName, defaultPXEFileName))
 
 			err := c.serveTFTP()
 			if err != nil {
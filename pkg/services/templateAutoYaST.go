@@ -0,0 +1,245 @@
+package services
+
+import "fmt"
+
+// autoyastTemplate is the built-in SUSE (SLES/openSUSE) AutoYaST profile, it can be replaced by setting the
+// installerTemplate or installerTemplatePath of a boot configuration. Any packages prefixed with pattern: are installed
+// as software patterns, and the boot partitions that the firmware requires are added by AutoYaST.
+const autoyastTemplate = `<?xml version="1.0"?>
+<!DOCTYPE profile>
+<profile xmlns="http://www.suse.com/1.0/yast2ns" xmlns:config="http://www.suse.com/1.0/configns">
+  <general>
+    <mode>
+      <confirm config:type="boolean">false</confirm>
+      <final_reboot config:type="boolean">true</final_reboot>
+    </mode>
+  </general>
+  <language>
+    <language>en_US</language>
+  </language>
+  <keyboard>
+    <keymap>english-us</keymap>
+  </keyboard>
+  <timezone>
+    <hwclock>UTC</hwclock>
+    <timezone>Etc/UTC</timezone>
+  </timezone>
+{{- if .Host.NTPServer }}
+  <ntp-client>
+    <ntp_policy>auto</ntp_policy>
+    <ntp_servers config:type="list">
+      <ntp_server>
+        <address>{{ xml .Host.NTPServer }}</address>
+        <iburst config:type="boolean">true</iburst>
+      </ntp_server>
+    </ntp_servers>
+    <ntp_sync>systemd</ntp_sync>
+  </ntp-client>
+{{- end }}
+  <networking>
+    <keep_install_network config:type="boolean">false</keep_install_network>
+    <dns>
+      <dhcp_hostname config:type="boolean">{{ if .Host.ServerName }}false{{ else }}true{{ end }}</dhcp_hostname>
+{{- if .Host.ServerName }}
+      <hostname>{{ xml .Host.ServerName }}</hostname>
+{{- end }}
+{{- if .NameServers }}
+      <nameservers config:type="list">
+{{- range .NameServers }}
+        <nameserver>{{ xml . }}</nameserver>
+{{- end }}
+      </nameservers>
+{{- end }}
+    </dns>
+    <interfaces config:type="list">
+      <interface>
+        <name>{{ xml (default "eth0" .Host.Adapter) }}</name>
+        <startmode>auto</startmode>
+{{- if .Host.IPAddress }}
+        <bootproto>static</bootproto>
+        <ipaddr>{{ xml .Host.IPAddress }}</ipaddr>
+{{- if .Host.Subnet }}
+        <netmask>{{ xml .Host.Subnet }}</netmask>
+{{- end }}
+{{- else }}
+        <bootproto>dhcp</bootproto>
+{{- end }}
+      </interface>
+    </interfaces>
+{{- if .MAC }}
+    <net-udev config:type="list">
+      <rule>
+        <name>{{ xml (default "eth0" .Host.Adapter) }}</name>
+        <rule>ATTR{address}</rule>
+        <value>{{ xml .MAC }}</value>
+      </rule>
+    </net-udev>
+{{- end }}
+{{- if and .Host.IPAddress .Host.Gateway }}
+    <routing>
+      <routes config:type="list">
+        <route>
+          <destination>default</destination>
+          <gateway>{{ xml .Host.Gateway }}</gateway>
+          <device>-</device>
+        </route>
+      </routes>
+    </routing>
+{{- end }}
+  </networking>
+  <partitioning config:type="list">
+    <drive>
+{{- if .Host.DestinationDevice }}
+      <device>{{ xml .Host.DestinationDevice }}</device>
+{{- end }}
+      <initialize config:type="boolean">true</initialize>
+      <use>all</use>
+      <partitions config:type="list">
+{{- if enabled .Host.LVMEnable }}
+        <partition>
+          <lvm_group>system</lvm_group>
+          <size>max</size>
+        </partition>
+{{- else }}
+{{- if not (enabled .Host.SwapDisabled) }}
+        <partition>
+          <mount>swap</mount>
+          <filesystem config:type="symbol">swap</filesystem>
+          <size>2GiB</size>
+        </partition>
+{{- end }}
+        <partition>
+          <mount>/</mount>
+          <filesystem config:type="symbol">btrfs</filesystem>
+          <size>max</size>
+        </partition>
+{{- end }}
+      </partitions>
+    </drive>
+{{- if enabled .Host.LVMEnable }}
+    <drive>
+      <device>/dev/system</device>
+      <type config:type="symbol">CT_LVM</type>
+      <partitions config:type="list">
+{{- if not (enabled .Host.SwapDisabled) }}
+        <partition>
+          <lv_name>swap</lv_name>
+          <mount>swap</mount>
+          <filesystem config:type="symbol">swap</filesystem>
+          <size>2GiB</size>
+        </partition>
+{{- end }}
+        <partition>
+          <lv_name>root</lv_name>
+          <mount>/</mount>
+          <filesystem config:type="symbol">xfs</filesystem>
+          <size>max</size>
+        </partition>
+      </partitions>
+    </drive>
+{{- end }}
+  </partitioning>
+  <software>
+    <install_recommended config:type="boolean">true</install_recommended>
+    <patterns config:type="list">
+      <pattern>base</pattern>
+{{- range fields .Host.Packages }}
+{{- if hasPrefix "pattern:" . }}
+      <pattern>{{ xml (trimPrefix "pattern:" .) }}</pattern>
+{{- end }}
+{{- end }}
+    </patterns>
+    <packages config:type="list">
+      <package>openssh</package>
+{{- range fields .Host.Packages }}
+{{- if not (hasPrefix "pattern:" .) }}
+      <package>{{ xml . }}</package>
+{{- end }}
+{{- end }}
+    </packages>
+  </software>
+  <services-manager>
+    <services>
+      <enable config:type="list">
+        <service>sshd</service>
+      </enable>
+    </services>
+  </services-manager>
+  <firewall>
+    <enable_firewall config:type="boolean">false</enable_firewall>
+    <start_firewall config:type="boolean">false</start_firewall>
+  </firewall>
+  <users config:type="list">
+    <user>
+      <username>root</username>
+{{- if .SSHKey }}
+      <authorized_keys config:type="list">
+        <listentry>{{ xml .SSHKey }}</listentry>
+      </authorized_keys>
+{{- end }}
+    </user>
+{{- if .Host.Username }}
+    <user>
+      <username>{{ xml .Host.Username }}</username>
+      <fullname>{{ xml .Host.Username }}</fullname>
+      <home>/home/{{ xml .Host.Username }}</home>
+{{- if .Host.Password }}
+      <encrypted config:type="boolean">{{ hasPrefix "$" .Host.Password }}</encrypted>
+      <user_password>{{ xml .Host.Password }}</user_password>
+{{- end }}
+{{- if .SSHKey }}
+      <authorized_keys config:type="list">
+        <listentry>{{ xml .SSHKey }}</listentry>
+      </authorized_keys>
+{{- end }}
+    </user>
+{{- end }}
+  </users>
+{{- if .Host.Username }}
+  <files config:type="list">
+    <file>
+      <file_path>/etc/sudoers.d/{{ xml .Host.Username }}</file_path>
+      <file_contents><![CDATA[{{ .Host.Username }} ALL=(ALL) NOPASSWD: ALL
+]]></file_contents>
+      <file_owner>root.root</file_owner>
+      <file_permissions>440</file_permissions>
+    </file>
+  </files>
+{{- end }}
+{{- if or .InstallingURL .CompleteURL }}
+  <scripts>
+{{- with .InstallingURL }}
+    <pre-scripts config:type="list">
+      <script>
+        <filename>plunder-installing.sh</filename>
+        <source><![CDATA[curl -s -X POST -o /dev/null {{ . }}
+]]></source>
+      </script>
+    </pre-scripts>
+{{- end }}
+{{- with .CompleteURL }}
+    <chroot-scripts config:type="list">
+      <script>
+        <chrooted config:type="boolean">false</chrooted>
+        <filename>plunder-complete.sh</filename>
+        <source><![CDATA[curl -s -X POST -o /dev/null {{ . }}
+]]></source>
+      </script>
+    </chroot-scripts>
+{{- end }}
+  </scripts>
+{{- end }}
+</profile>
+`
+
+// autoyastInstallURL returns the installation source of a server, this is the repository (if one is set) otherwise the
+// contents of the ISO. It is blank if there is neither, in which case install= should be part of the cmdline.
+func (config *HostConfig) autoyastInstallURL(isoPrefix string) string {
+	if config.RepositoryAddress != "" {
+		return fmt.Sprintf("http://%s%s", config.RepositoryAddress, config.MirrorDirectory)
+	}
+	if isoPrefix != "" {
+		return fmt.Sprintf("http://%s/%s/", HttpAddress, isoPrefix)
+	}
+	return ""
+}
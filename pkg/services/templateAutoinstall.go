@@ -0,0 +1,88 @@
+package services
+
+// autoinstallTemplate is the built-in Ubuntu (subiquity) autoinstall configuration, it is served as the cloud-init
+// user-data of the live server installer. The user is created through the user-data section rather than the identity
+// section, as the identity section requires a crypted password. It can be replaced by setting the installerTemplate or
+// installerTemplatePath of a boot configuration.
+const autoinstallTemplate = `#cloud-config
+autoinstall:
+  version: 1
+  locale: en_US.UTF-8
+  keyboard:
+    layout: us
+  refresh-installer:
+    update: false
+{{- if .Host.RepositoryAddress }}
+  apt:
+    primary:
+      - arches: [default]
+        uri: {{ quote (printf "http://%s%s" .Host.RepositoryAddress .Host.MirrorDirectory) }}
+{{- end }}
+  network:
+{{ indent 4 .NetworkConfig }}
+  storage:
+    layout:
+      name: {{ if enabled .Host.LVMEnable }}lvm{{ else }}direct{{ end }}
+{{- if enabled .Host.SwapDisabled }}
+    swap:
+      size: 0
+{{- end }}
+  ssh:
+    install-server: true
+    allow-pw: {{ if .Host.Password }}true{{ else }}false{{ end }}
+{{- if .SSHKey }}
+    authorized-keys:
+      - {{ quote .SSHKey }}
+{{- end }}
+{{- with fields .Host.Packages }}
+  packages:
+{{- range . }}
+    - {{ quote . }}
+{{- end }}
+{{- end }}
+  user-data:
+{{- if .Host.ServerName }}
+    hostname: {{ quote .Host.ServerName }}
+{{- end }}
+{{- if .Host.NTPServer }}
+    ntp:
+      enabled: true
+      servers:
+        - {{ quote .Host.NTPServer }}
+{{- end }}
+{{- if .Host.Username }}
+    users:
+      - name: {{ quote .Host.Username }}
+        shell: /bin/bash
+        groups: [adm, sudo]
+{{- if hasPrefix "$" .Host.Password }}
+        passwd: {{ quote .Host.Password }}
+        lock_passwd: false
+{{- else if .Host.Password }}
+        plain_text_passwd: {{ quote .Host.Password }}
+        lock_passwd: false
+{{- end }}
+{{- if .SSHKey }}
+        ssh_authorized_keys:
+          - {{ quote .SSHKey }}
+{{- end }}
+{{- end }}
+{{- with .InstallingURL }}
+  early-commands:
+    - {{ quote (printf "curl -s -X POST -o /dev/null %s" .) }}
+{{- end }}
+{{- if or .Host.Username .CompleteURL }}
+  late-commands:
+{{- if .Host.Username }}
+    - {{ quote (printf "echo '%s ALL=(ALL) NOPASSWD:ALL' > /target/etc/sudoers.d/%s" .Host.Username .Host.Username) }}
+    - {{ quote (printf "chmod 0440 /target/etc/sudoers.d/%s" .Host.Username) }}
+{{- end }}
+{{- with .CompleteURL }}
+    - {{ quote (printf "curl -s -X POST -o /dev/null %s" .) }}
+{{- end }}
+{{- end }}
+{{- with .FailedURL }}
+  error-commands:
+    - {{ quote (printf "curl -s -X POST -o /dev/null %s" .) }}
+{{- end }}
+`
@@ -8,8 +8,17 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
+// bootyConfig adds the plunder callbacks to the BOOTy configuration, BOOTy POSTs to the installingURL before the image
+// is written and to the completeURL once it has been written (or to the failedURL if it fails)
+type bootyConfig struct {
+	types.BootyConfig
+	InstallingURL string `json:"installingURL,omitempty"`
+	CompleteURL   string `json:"completeURL,omitempty"`
+	FailedURL     string `json:"failedURL,omitempty"`
+}
+
 //BuildBOOTYconfig - Creates a new presseed configuration using the passed data
-func (config *HostConfig) BuildBOOTYconfig() string {
+func (config *HostConfig) BuildBOOTYconfig(dashMac string) string {
 	a := types.BootyConfig{}
 
 	// set the required action
@@ -64,6 +73,11 @@ func (config *HostConfig) BuildBOOTYconfig() string {
 	a.DropToShell = *config.ShellOnFail
 	a.NameServer = config.NameServer
 
-	b, _ := json.Marshal(a)
+	b, _ := json.Marshal(bootyConfig{
+		BootyConfig:   a,
+		InstallingURL: installingURL(dashMac),
+		CompleteURL:   completeURL(dashMac),
+		FailedURL:     failedURL(dashMac),
+	})
 	return string(b)
 }
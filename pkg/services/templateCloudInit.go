@@ -0,0 +1,127 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+// The files that cloud-init requests from a NoCloud datasource, these are served from a directory named after the
+// mac address of a server e.g. /00-11-22-33-44-55/user-data
+var cloudInitFiles = map[string]bool{
+	"meta-data":      true,
+	"user-data":      true,
+	"vendor-data":    true,
+	"network-config": true,
+}
+
+// cloudInitUserDataTemplate is the built-in cloud-init user-data, it can be replaced by setting the installerTemplate
+// or installerTemplatePath of a boot configuration
+const cloudInitUserDataTemplate = `#cloud-config
+{{- if .Host.ServerName }}
+hostname: {{ quote .Host.ServerName }}
+manage_etc_hosts: true
+{{- end }}
+{{- if .Host.Username }}
+users:
+  - name: {{ quote .Host.Username }}
+    sudo: "ALL=(ALL) NOPASSWD:ALL"
+    shell: /bin/bash
+{{- if .Host.Password }}
+    lock_passwd: false
+    plain_text_passwd: {{ quote .Host.Password }}
+{{- end }}
+{{- if .SSHKey }}
+    ssh_authorized_keys:
+      - {{ quote .SSHKey }}
+{{- end }}
+{{- end }}
+{{- if .Host.NTPServer }}
+ntp:
+  enabled: true
+  servers:
+    - {{ quote .Host.NTPServer }}
+{{- end }}
+{{- with fields .Host.Packages }}
+packages:
+{{- range . }}
+  - {{ quote . }}
+{{- end }}
+{{- end }}
+`
+
+// cloudInitVendorData is served as the vendor-data, as plunder has no vendor specific configuration
+const cloudInitVendorData = "#cloud-config\n"
+
+// cloudInitMetaData is the NoCloud meta-data
+type cloudInitMetaData struct {
+	InstanceID    string `json:"instance-id"`
+	LocalHostname string `json:"local-hostname,omitempty"`
+}
+
+// cloudInitNetworkConfig is the (version 2) network configuration
+type cloudInitNetworkConfig struct {
+	Version   int                                `json:"version"`
+	Ethernets map[string]cloudInitNetworkAdapter `json:"ethernets"`
+}
+
+type cloudInitNetworkAdapter struct {
+	Match       map[string]string           `json:"match"`
+	SetName     string                      `json:"set-name,omitempty"`
+	DHCP4       bool                        `json:"dhcp4"`
+	Addresses   []string                    `json:"addresses,omitempty"`
+	Gateway4    string                      `json:"gateway4,omitempty"`
+	Nameservers *cloudInitNetworkNameserver `json:"nameservers,omitempty"`
+}
+
+type cloudInitNetworkNameserver struct {
+	Addresses []string `json:"addresses"`
+}
+
+// BuildCloudInitMetaData - Creates the cloud-init meta-data for a server
+func (config *HostConfig) BuildCloudInitMetaData(mac string) string {
+	metaData := cloudInitMetaData{
+		// The instance-id is unique to the server, cloud-init uses it to identify a first boot
+		InstanceID:    "plunder-" + strings.Replace(mac, ":", "-", -1),
+		LocalHostname: config.ServerName,
+	}
+	b, err := yaml.Marshal(metaData)
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	return string(b)
+}
+
+// BuildCloudInitNetworkConfig - Creates the cloud-init network configuration for a server, the adapter is matched by
+// its mac address and is statically addressed if the server has an address, otherwise DHCP is used
+func (config *HostConfig) BuildCloudInitNetworkConfig(mac string) string {
+	adapter := cloudInitNetworkAdapter{
+		Match:   map[string]string{"macaddress": strings.ToLower(mac)},
+		SetName: config.Adapter,
+		DHCP4:   true,
+	}
+
+	if config.IPAddress != "" {
+		adapter.DHCP4 = false
+		adapter.Addresses = []string{config.addressCIDR()}
+		adapter.Gateway4 = config.Gateway
+		if nameservers := config.nameServers(); len(nameservers) != 0 {
+			adapter.Nameservers = &cloudInitNetworkNameserver{Addresses: nameservers}
+		}
+	}
+
+	name := config.Adapter
+	if name == "" {
+		name = "primary"
+	}
+	networkConfig := cloudInitNetworkConfig{
+		Version:   2,
+		Ethernets: map[string]cloudInitNetworkAdapter{name: adapter},
+	}
+	b, err := yaml.Marshal(networkConfig)
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	return string(b)
+}
@@ -19,63 +19,215 @@ kernel=b.b00`
 // The Modules list all of the required modules needed to deploy vSphere
 const modules67us = `modules=/jumpstrt.gz --- /useropts.gz --- /features.gz --- /k.b00 --- /chardevs.b00 --- /user.b00 --- /procfs.b00 --- /uc_intel.b00 --- /uc_amd.b00 --- /uc_hygon.b00 --- /vmx.v00 --- /vim.v00 --- /sb.v00 --- /s.v00 --- /ata_liba.v00 --- /ata_pata.v00 --- /ata_pata.v01 --- /ata_pata.v02 --- /ata_pata.v03 --- /ata_pata.v04 --- /ata_pata.v05 --- /ata_pata.v06 --- /ata_pata.v07 --- /block_cc.v00 --- /bnxtnet.v00 --- /bnxtroce.v00 --- /brcmfcoe.v00 --- /char_ran.v00 --- /ehci_ehc.v00 --- /elxiscsi.v00 --- /elxnet.v00 --- /hid_hid.v00 --- /i40en.v00 --- /iavmd.v00 --- /igbn.v00 --- /ima_qla4.v00 --- /ipmi_ipm.v00 --- /ipmi_ipm.v01 --- /ipmi_ipm.v02 --- /iser.v00 --- /ixgben.v00 --- /lpfc.v00 --- /lpnic.v00 --- /lsi_mr3.v00 --- /lsi_msgp.v00 --- /lsi_msgp.v01 --- /lsi_msgp.v02 --- /misc_cni.v00 --- /misc_dri.v00 --- /mtip32xx.v00 --- /ne1000.v00 --- /nenic.v00 --- /net_bnx2.v00 --- /net_bnx2.v01 --- /net_cdc_.v00 --- /net_cnic.v00 --- /net_e100.v00 --- /net_e100.v01 --- /net_enic.v00 --- /net_fcoe.v00 --- /net_forc.v00 --- /net_igb.v00 --- /net_ixgb.v00 --- /net_libf.v00 --- /net_mlx4.v00 --- /net_mlx4.v01 --- /net_nx_n.v00 --- /net_tg3.v00 --- /net_usbn.v00 --- /net_vmxn.v00 --- /nfnic.v00 --- /nhpsa.v00 --- /nmlx4_co.v00 --- /nmlx4_en.v00 --- /nmlx4_rd.v00 --- /nmlx5_co.v00 --- /nmlx5_rd.v00 --- /ntg3.v00 --- /nvme.v00 --- /nvmxnet3.v00 --- /nvmxnet3.v01 --- /ohci_usb.v00 --- /pvscsi.v00 --- /qcnic.v00 --- /qedentv.v00 --- /qfle3.v00 --- /qfle3f.v00 --- /qfle3i.v00 --- /qflge.v00 --- /sata_ahc.v00 --- /sata_ata.v00 --- /sata_sat.v00 --- /sata_sat.v01 --- /sata_sat.v02 --- /sata_sat.v03 --- /sata_sat.v04 --- /scsi_aac.v00 --- /scsi_adp.v00 --- /scsi_aic.v00 --- /scsi_bnx.v00 --- /scsi_bnx.v01 --- /scsi_fni.v00 --- /scsi_hps.v00 --- /scsi_ips.v00 --- /scsi_isc.v00 --- /scsi_lib.v00 --- /scsi_meg.v00 --- /scsi_meg.v01 --- /scsi_meg.v02 --- /scsi_mpt.v00 --- /scsi_mpt.v01 --- /scsi_mpt.v02 --- /scsi_qla.v00 --- /shim_isc.v00 --- /shim_isc.v01 --- /shim_lib.v00 --- /shim_lib.v01 --- /shim_lib.v02 --- /shim_lib.v03 --- /shim_lib.v04 --- /shim_lib.v05 --- /shim_vmk.v00 --- /shim_vmk.v01 --- /shim_vmk.v02 --- /smartpqi.v00 --- /uhci_usb.v00 --- /usb_stor.v00 --- /usbcore_.v00 --- /vmkata.v00 --- /vmkfcoe.v00 --- /vmkplexe.v00 --- /vmkusb.v00 --- /vmw_ahci.v00 --- /xhci_xhc.v00 --- /elx_esx_.v00 --- /btldr.t00 --- /esx_dvfi.v00 --- /esx_ui.v00 --- /esxupdt.v00 --- /weaselin.t00 --- /lsu_hp_h.v00 --- /lsu_inte.v00 --- /lsu_lsi_.v00 --- /lsu_lsi_.v01 --- /lsu_lsi_.v02 --- /lsu_lsi_.v03 --- /lsu_smar.v00 --- /native_m.v00 --- /qlnative.v00 --- /rste.v00 --- /vmware_e.v00 --- /vsan.v00 --- /vsanheal.v00 --- /vsanmgmt.v00 --- /tools.t00 --- /xorg.v00 --- /imgdb.tgz --- /imgpayld.tgz`
 
-// kickstart67u2 const, this is the template for the actual installation of ESXi
-const kickstart67u2 = `accepteula 
-install --firstdisk --overwritevmfs 
-rootpw %s
+// kickstartESXiTemplate is the built-in template for the actual installation of ESXi, it can be replaced by setting the
+// installerTemplate or installerTemplatePath of a boot configuration
+const kickstartESXiTemplate = `accepteula
+{{- if .Host.DestinationDevice }}
+install --disk={{ .Host.DestinationDevice }} --overwritevmfs
+{{- else if .Host.InstallDiskMinSize }}
+# The disk is selected by its size in the %pre section
+%include /tmp/install.ks
+{{- else if .Host.InstallDiskModel }}
+install --firstdisk={{ .Host.InstallDiskModel }} --overwritevmfs
+{{- else }}
+install --firstdisk --overwritevmfs
+{{- end }}
+rootpw {{ .Host.Password }}
 reboot
-# vmserialnum --esx=PUT IN YOUR LICENSE KEY
- 
-#network configuration 
-network --bootproto=static --addvmportgroup=1 --ip=%s --netmask=%s --gateway=%s --nameserver=%s --hostname=%s
- 
+{{- if .Host.ProductKey }}
+vmserialnum --esx={{ .Host.ProductKey }}
+{{- end }}
+
+#network configuration
+{{- if .Host.IPAddress }}
+network --bootproto=static --addvmportgroup=1{{ if .Host.VLANID }} --vlanid={{ .Host.VLANID }}{{ end }} --ip={{ .Host.IPAddress }} --netmask={{ .Host.Subnet }} --gateway={{ .Host.Gateway }}{{ with .NameServers }} --nameserver={{ join . "," }}{{ end }} --hostname={{ .Host.ServerName }}
+{{- else }}
+network --bootproto=dhcp --addvmportgroup=1{{ if .Host.VLANID }} --vlanid={{ .Host.VLANID }}{{ end }}{{ if .Host.ServerName }} --hostname={{ .Host.ServerName }}{{ end }}
+{{- end }}
+{{- if and (not .Host.DestinationDevice) .Host.InstallDiskMinSize }}
+
+# select the first disk that is at least {{ .Host.InstallDiskMinSize }}GB (the size from esxcli is in MB)
+%pre --interpreter=busybox
+DISK=$(esxcli storage core device list | awk -v min={{ .Host.InstallDiskMinSize }} '/^[^ ]/ { disk = $1 } /^ +Size: / { if (!found && $2 >= min * 1024) { print disk; found = 1 } }')
+if [ -n "$DISK" ]; then
+  echo "install --disk=$DISK --overwritevmfs" > /tmp/install.ks
+else
+  echo "install --firstdisk{{ with .Host.InstallDiskModel }}={{ . }}{{ end }} --overwritevmfs" > /tmp/install.ks
+fi
+{{- end }}
+
+{{- with .InstallingURL }}
+
+# tell plunder that the installation has started
+%pre --interpreter=python
+import urllib.request
+urllib.request.urlopen(urllib.request.Request("{{ . }}", data=b"", method="POST"))
+{{- end }}
+
+{{- with .CompleteURL }}
+
+# tell plunder that the installation has finished, so that the server boots from its disk
+%post --interpreter=python
+import urllib.request
+urllib.request.urlopen(urllib.request.Request("{{ . }}", data=b"", method="POST"))
+{{- end }}
+
 # run the following command only on the firstboot
-%%firstboot --interpreter=busybox
- 
- 
+%firstboot --interpreter=busybox
+{{- if not (enabled .Host.SSHDisabled) }}
+
 # enable & start remote ESXi Shell (SSH)
 vim-cmd hostsvc/enable_ssh
 vim-cmd hostsvc/start_ssh
- 
+{{- if .SSHKey }}
+echo "{{ .SSHKey }}" > /etc/ssh/keys-root/authorized_keys
+{{- end }}
+{{- end }}
+{{- if not (enabled .Host.ShellDisabled) }}
+
 # enable & start ESXi Shell (TSM)
 vim-cmd hostsvc/enable_esx_shell
 vim-cmd hostsvc/start_esx_shell
- 
+{{- end }}
+
 # enable High Performance
 # http://www.virtuallyghetto.com/2012/08/configuring-esxi-power-management.html
-esxcli system settings advanced set --option=/Power/CpuPolicy --string-value="High Performance" 
- 
+esxcli system settings advanced set --option=/Power/CpuPolicy --string-value="High Performance"
+
 # supress ESXi Shell shell warning - Thanks to Duncan (http://www.yellow-bricks.com/2011/07/21/esxi-5-suppressing-the-localremote-shell-warning/)
 esxcli system settings advanced set -o /UserVars/SuppressShellWarning -i 1
- 
+{{- if not (enabled .Host.IPv6Enabled) }}
+
 #Disable ipv6
 esxcli network ip set --ipv6-enabled=0
- 
-# NTP Configuration (thanks to http://www.virtuallyghetto.com)
+{{- end }}
+{{- if .Host.DatastoreName }}
+
+# rename the local datastore
+vim-cmd hostsvc/datastore/rename datastore1 "{{ .Host.DatastoreName }}"
+{{- end }}
+{{- with fields .Host.NTPServer }}
+
+# NTP Configuration, esxcli configures NTP from 7.0 Update 1 otherwise ntp.conf is written (thanks to http://www.virtuallyghetto.com)
+if ! esxcli system ntp set{{ range . }} --server={{ . }}{{ end }} --enabled=true; then
 cat > /etc/ntp.conf << __NTP_CONFIG__
 restrict default kod nomodify notrap noquerynopeer
 restrict 127.0.0.1
-server 129.6.15.28
-server 129.6.15.29
-server 129.6.15.30
- 
+{{- range . }}
+server {{ . }}
+{{- end }}
+
 __NTP_CONFIG__
- 
+
 /sbin/chkconfig ntpd on
+fi
+{{- end }}
+{{- with .Host.FirstbootCommands }}
+
+# additional commands
+{{- range . }}
+{{ . }}
+{{- end }}
+{{- end }}
 `
 
-//BuildESXiConfig - Creates a new presseed configuration using the passed data
+// The boot.cfg files of an ESXi ISO, for BIOS (mboot.c32) and then UEFI
+var esxiBootCfgPaths = []string{"/boot.cfg", "/efi/boot/boot.cfg"}
+
+// buildESXiBootCfg will create the boot.cfg for a deployment, this is read from the ISO of the boot configuration so
+// that it matches the ESXi build. Without an ISO the installer is expected to have been extracted to <repoaddress>/vsphere.
+func (b *BootConfig) buildESXiBootCfg(deployment DeploymentConfig) (string, error) {
+	if b.ISOPrefix == "" || b.ISOPath == "" {
+		return deployment.ConfigHost.BuildESXiConfig(), nil
+	}
+	bootCfg, err := readESXiBootCfg(b.ISOPrefix)
+	if err != nil {
+		return "", err
+	}
+	isoURL := fmt.Sprintf("http://%s/%s", HttpAddress, b.ISOPrefix)
+	kickstartURL := fmt.Sprintf("http://%s/%s.ks", HttpAddress, strings.Replace(deployment.MAC, ":", "-", -1))
+	return RewriteESXiBootCfg(bootCfg, isoURL, kickstartURL), nil
+}
+
+// readESXiBootCfg will read the boot.cfg from an ESXi ISO
+func readESXiBootCfg(isoPrefix string) (string, error) {
+	isoMapperLock.RLock()
+	image := isoMapper[isoPrefix]
+	isoMapperLock.RUnlock()
+
+	if image == nil {
+		return "", fmt.Errorf("Unable to find ISO Prefix [%s]", isoPrefix)
+	}
+	for _, bootCfgPath := range esxiBootCfgPaths {
+		// Names are upper case in an image with Joliet names
+		for _, p := range []string{bootCfgPath, strings.ToUpper(bootCfgPath)} {
+			if image.exists(p) {
+				b, err := image.readFile(p)
+				if err != nil {
+					return "", err
+				}
+				return string(b), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Unable to find a boot.cfg in ISO Prefix [%s]", isoPrefix)
+}
+
+// RewriteESXiBootCfg - Rewrites the boot.cfg from an ESXi ISO so that the kernel and modules are loaded over HTTP from
+// the isoURL (the prefix), and the installer reads the kickstart from the kickstartURL
+func RewriteESXiBootCfg(bootCfg, isoURL, kickstartURL string) string {
+	var lines []string
+	var prefix bool
+	for _, line := range strings.Split(strings.Replace(bootCfg, "\r", "", -1), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+			continue
+		}
+
+		switch key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]); key {
+		case "prefix":
+			prefix = true
+			line = fmt.Sprintf("prefix=%s", isoURL)
+		case "kernel":
+			// Paths beginning with a / ignore the prefix
+			line = fmt.Sprintf("kernel=%s", strings.TrimPrefix(value, "/"))
+		case "modules":
+			modules := strings.Split(value, "---")
+			for i := range modules {
+				modules[i] = strings.TrimPrefix(strings.TrimSpace(modules[i]), "/")
+			}
+			line = fmt.Sprintf("modules=%s", strings.Join(modules, " --- "))
+		case "kernelopt":
+			// Newer builds boot the installer from the CD-ROM (cdromBoot), and any kickstart is replaced
+			var options []string
+			for _, option := range strings.Fields(value) {
+				if option != "cdromBoot" && !strings.HasPrefix(option, "ks=") {
+					options = append(options, option)
+				}
+			}
+			options = append(options, fmt.Sprintf("ks=%s", kickstartURL))
+			line = fmt.Sprintf("kernelopt=%s", strings.Join(options, " "))
+		}
+		lines = append(lines, line)
+	}
+	// Older builds have no prefix
+	if !prefix {
+		lines = append(lines, fmt.Sprintf("prefix=%s", isoURL))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// BuildESXiConfig - Creates the boot.cfg for the ESXi 6.7 Update 2 installer, extracted to <repoaddress>/vsphere
 func (config *HostConfig) BuildESXiConfig() string {
 	modules := strings.Replace(modules67us, "/", "", -1)
 	vSphereConfig := fmt.Sprintf("%s\n%s", fmt.Sprintf(bootcfg67u2, config.RepositoryAddress), modules)
 	return vSphereConfig
 }
 
-//BuildESXiKickStart - Creates a new presseed configuration using the passed data
+//BuildESXiKickStart - Creates a new vSphere kickstart configuration using the passed data
 func (config *HostConfig) BuildESXiKickStart() string {
-
-	// vSphere Kickststart
-	vKickStart := fmt.Sprintf(kickstart67u2, config.Password, config.IPAddress, config.Subnet, config.Gateway, config.NameServer, config.ServerName)
-
-	return vKickStart
+	return config.buildDefaultInstallerConfig("vsphere")
 }
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ignitionVersion is the Ignition specification of the generated configuration, it is understood by Fedora CoreOS,
+// RHCOS and Flatcar (which accept any 3.x configuration up to the version they support)
+const ignitionVersion = "3.0.0"
+
+// ignitionDefaultUser is the user that the SSH key is added to if no username is set
+const ignitionDefaultUser = "core"
+
+// ignitionDefaultInstallDevice is the disk that CoreOS is installed to if no destinationDevice is set
+const ignitionDefaultInstallDevice = "/dev/sda"
+
+type ignitionConfig struct {
+	Ignition ignitionMetadata `json:"ignition"`
+	Passwd   ignitionPasswd   `json:"passwd"`
+	Storage  ignitionStorage  `json:"storage"`
+	Systemd  ignitionSystemd  `json:"systemd"`
+}
+
+type ignitionMetadata struct {
+	Version string `json:"version"`
+}
+
+type ignitionPasswd struct {
+	Users []ignitionUser `json:"users,omitempty"`
+}
+
+type ignitionUser struct {
+	Name              string   `json:"name"`
+	PasswordHash      string   `json:"passwordHash,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files,omitempty"`
+}
+
+type ignitionFile struct {
+	Path      string           `json:"path"`
+	Mode      int              `json:"mode"`
+	Overwrite bool             `json:"overwrite"`
+	Contents  ignitionContents `json:"contents"`
+}
+
+type ignitionContents struct {
+	Source string `json:"source"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units,omitempty"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  *bool  `json:"enabled,omitempty"`
+	Contents string `json:"contents,omitempty"`
+}
+
+// newIgnitionFile creates a file, the contents are encoded as a data URL
+func newIgnitionFile(path string, mode int, contents string) ignitionFile {
+	return ignitionFile{
+		Path:      path,
+		Mode:      mode,
+		Overwrite: true,
+		Contents: ignitionContents{
+			Source: "data:;base64," + base64.StdEncoding.EncodeToString([]byte(contents)),
+		},
+	}
+}
+
+// BuildIgnitionConfig - Creates an Ignition configuration for a CoreOS (or Flatcar) server
+func (config *HostConfig) BuildIgnitionConfig(mac string) (string, error) {
+	ignition := ignitionConfig{
+		Ignition: ignitionMetadata{Version: ignitionVersion},
+	}
+
+	// Users
+	user := ignitionUser{Name: config.Username}
+	if user.Name == "" {
+		user.Name = ignitionDefaultUser
+	}
+	if user.Name != ignitionDefaultUser {
+		user.Groups = []string{"wheel", "sudo"}
+	}
+	if key := config.publicKey(); key != "" {
+		user.SSHAuthorizedKeys = []string{key}
+	}
+	// Ignition can only set a crypted password
+	if strings.HasPrefix(config.Password, "$") {
+		user.PasswordHash = config.Password
+	}
+	ignition.Passwd.Users = append(ignition.Passwd.Users, user)
+
+	// Hostname
+	if config.ServerName != "" {
+		ignition.Storage.Files = append(ignition.Storage.Files, newIgnitionFile("/etc/hostname", 0644, config.ServerName+"\n"))
+	}
+
+	// Static networking is written for both NetworkManager (Fedora CoreOS/RHCOS) and systemd-networkd (Flatcar)
+	if config.IPAddress != "" {
+		ignition.Storage.Files = append(ignition.Storage.Files,
+			newIgnitionFile("/etc/NetworkManager/system-connections/plunder.nmconnection", 0600, config.buildNetworkManagerConfig(mac)),
+			newIgnitionFile("/etc/systemd/network/00-plunder.network", 0644, config.buildNetworkdConfig(mac)),
+		)
+	}
+
+	// Systemd units
+	for _, unit := range config.SystemdUnits {
+		ignition.Systemd.Units = append(ignition.Systemd.Units, ignitionUnit{
+			Name:     unit.Name,
+			Enabled:  unit.Enabled,
+			Contents: unit.Contents,
+		})
+	}
+
+	b, err := json.Marshal(ignition)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// buildNetworkManagerConfig creates a NetworkManager connection (keyfile) for the static address of a server
+func (config *HostConfig) buildNetworkManagerConfig(mac string) string {
+	var connection strings.Builder
+	fmt.Fprintf(&connection, "[connection]\nid=plunder\ntype=ethernet\n")
+	fmt.Fprintf(&connection, "\n[ethernet]\nmac-address=%s\n", strings.ToUpper(mac))
+	fmt.Fprintf(&connection, "\n[ipv4]\nmethod=manual\naddress1=%s", config.addressCIDR())
+	if config.Gateway != "" {
+		fmt.Fprintf(&connection, ",%s", config.Gateway)
+	}
+	fmt.Fprintf(&connection, "\n")
+	if nameservers := config.nameServers(); len(nameservers) != 0 {
+		fmt.Fprintf(&connection, "dns=%s;\n", strings.Join(nameservers, ";"))
+	}
+	fmt.Fprintf(&connection, "\n[ipv6]\nmethod=auto\n")
+	return connection.String()
+}
+
+// buildNetworkdConfig creates a systemd-networkd network for the static address of a server
+func (config *HostConfig) buildNetworkdConfig(mac string) string {
+	var network strings.Builder
+	fmt.Fprintf(&network, "[Match]\nMACAddress=%s\n", strings.ToLower(mac))
+	fmt.Fprintf(&network, "\n[Network]\nAddress=%s\n", config.addressCIDR())
+	if config.Gateway != "" {
+		fmt.Fprintf(&network, "Gateway=%s\n", config.Gateway)
+	}
+	for _, nameserver := range config.nameServers() {
+		fmt.Fprintf(&network, "DNS=%s\n", nameserver)
+	}
+	return network.String()
+}
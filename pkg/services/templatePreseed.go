@@ -1,14 +1,8 @@
 package services
 
-import (
-	"encoding/base64"
-	"fmt"
-
-	log "github.com/sirupsen/logrus"
-)
-
-// Preseed const, this is the basis for the configuration that will be modified per use-case
-const preseedHead = `
+// preseedTemplate is the built-in debian-installer (Debian/Ubuntu) preseed, it can be replaced by setting the
+// installerTemplate or installerTemplatePath of a boot configuration
+const preseedTemplate = `
 # Force debconf priority to critical.
 debconf debconf/priority select critical
 # Override default frontend to Noninteractive
@@ -25,33 +19,11 @@ d-i keyboard-configuration/layoutcode string us
 d-i clock-setup/utc boolean true
 d-i time/zone string Europe/GMT
 d-i clock-setup/ntp boolean true
-d-i clock-setup/ntp-server string 1.pl.pool.ntp.org
+d-i clock-setup/ntp-server string {{ default "1.pl.pool.ntp.org" .Host.NTPServer }}
 
 ### Preseed Early
-d-i preseed/early_command string kill-all-dhcp; netcfg
-`
-
-const preseedNet = `
-### Network configuration
-d-i netcfg/wireless_wep string
-
-# Set network interface or 'auto'
-d-i netcfg/choose_interface select %s
-
-# Any hostname and domain names assigned from dhcp take precedence over
-d-i netcfg/get_gateway string %s
-d-i netcfg/get_ipaddress string %s
-d-i netcfg/get_nameservers string %s
-d-i netcfg/get_netmask string %s
-d-i netcfg/use_dhcp string
-d-i netcfg/disable_dhcp boolean true
-
-d-i netcfg/get_hostname string ubuntu
-d-i netcfg/get_domain string internal
-
-d-i netcfg/hostname string %s`
-
-const preseedLVMDisk = `
+d-i preseed/early_command string kill-all-dhcp; netcfg{{ with .InstallingURL }}; wget -q -O /dev/null --post-data=installing {{ . }}{{ end }}
+{{ if enabled .Host.LVMEnable }}
 d-i partman-auto/method string lvm
 
 # If one of the disks that are going to be automatically partitioned
@@ -90,9 +62,17 @@ d-i cdrom-detect/eject boolean true
 
 ### Preseeding other packages
 popularity-contest popularity-contest/participate boolean false
-`
+{{ if enabled .Host.SwapDisabled }}
+d-i partman-auto/choose_recipe select parlayfs
+d-i partman-auto/expert_recipe string                         \
+parlayfs ::                                                   \
+269 269 269 ext4 $primary{ } $bootable{ } $defaultignore{ } $lvmignore{ } mountpoint{ /boot } method{ format } format{ } use_filesystem{ } filesystem{ ext4 } . \
+900 10000 -1 ext4 $lvmok{ } mountpoint{ / } lv_name{ root } in_vg { ubuntu-vg } method{ format } format{ } use_filesystem{ } filesystem{ ext4 } .
 
-const preseedLVMDiskRecipe = `
+# will result in a zero swapfile being created
+d-i partman-swapfile/percentage string 0
+d-i partman-swapfile/size string 0
+{{ else }}
 d-i partman-auto/choose_recipe select parlayfs
 d-i partman-auto/expert_recipe string                         \
 parlayfs ::                                                   \
@@ -114,26 +94,7 @@ parlayfs ::                                                   \
         use_filesystem{ }                                     \
         filesystem{ ext4 }                                    \
         .
-`
-
-const preseedLVMDiskRecipe2 = `
-d-i partman-auto/choose_recipe select parlayfs
-d-i partman-auto/expert_recipe string                         \
-parlayfs ::                                                   \
-269 269 269 ext4 $primary{ } $bootable{ } $defaultignore{ } $lvmignore{ } mountpoint{ /boot } method{ format } format{ } use_filesystem{ } filesystem{ ext4 } . \
-900 10000 -1 ext4 $lvmok{ } mountpoint{ / } lv_name{ root } in_vg { ubuntu-vg } method{ format } format{ } use_filesystem{ } filesystem{ ext4 } .
-`
-
-const preseedLVMDiskDisableSwap = `
-# will result in a zero swapfile being created
-d-i partman-swapfile/percentage string 0
-d-i partman-swapfile/size string 0
-`
-const preseedDiskAtomic = `
-d-i partman-auto/choose_recipe select atomic
-`
-
-const preseedDisk = `
+{{ end }}{{ else }}
 ### Partitions
 d-i partman/mount_style select label
 
@@ -193,37 +154,36 @@ d-i partman-auto/expert_recipe string         \
          method{ format } format{ }           \
          use_filesystem{ } filesystem{ ext4 } \
          mountpoint{ / } .                    \
-`
-
-const swap = `      65536 65536 65536 linux-swap            \
+{{ if enabled .Host.SwapDisabled }}
+partman-basicfilesystems partman-basicfilesystems/no_swap boolean false
+{{ else }}      65536 65536 65536 linux-swap            \
 $primary{ }                          \
-method{ swap } format{ } .`
+method{ swap } format{ } .{{ end }}{{ end }}
+### Network configuration
+d-i netcfg/wireless_wep string
 
-const noswap = `
-partman-basicfilesystems partman-basicfilesystems/no_swap boolean false
-`
+# Set network interface or 'auto'
+d-i netcfg/choose_interface select {{ default "auto" .Host.Adapter }}
 
-const preseedUsers = `
-### Account setup
-d-i passwd/root-login boolean false
-d-i passwd/make-user boolean true
-d-i passwd/user-fullname string %s
-d-i passwd/username string %s
+# Any hostname and domain names assigned from dhcp take precedence over
+d-i netcfg/get_gateway string {{ .Host.Gateway }}
+d-i netcfg/get_ipaddress string {{ .Host.IPAddress }}
+d-i netcfg/get_nameservers string {{ .Host.NameServer }}
+d-i netcfg/get_netmask string {{ .Host.Subnet }}
+d-i netcfg/use_dhcp string
+d-i netcfg/disable_dhcp boolean true
 
-d-i passwd/user-password password %s
-d-i passwd/user-password-again password %s
-d-i user-setup/allow-password-weak boolean true
-d-i user-setup/encrypt-home boolean false
-`
+d-i netcfg/get_hostname string {{ .Host.ServerName }}
+d-i netcfg/get_domain string internal
 
-const preseedPkg = `
+d-i netcfg/hostname string {{ .Host.ServerName }}
 ### Apt setup
 d-i apt-setup/restricted boolean true
 d-i apt-setup/universe boolean false
-di- apt-setup/security_host %s
-d-i apt-setup/security_path string %s
-d-i mirror/http/hostname string %s
-d-i mirror/http/directory string %s
+d-i apt-setup/security_host string {{ .Host.RepositoryAddress }}
+d-i apt-setup/security_path string {{ .Host.MirrorDirectory }}
+d-i mirror/http/hostname string {{ .Host.RepositoryAddress }}
+d-i mirror/http/directory string {{ .Host.MirrorDirectory }}
 d-i mirror/country string manual
 d-i mirror/http/proxy string
 
@@ -238,7 +198,7 @@ d-i pkgsel/ubuntu-standard boolean false
 # Allowed values: none, safe-upgrade, full-upgrade
 d-i pkgsel/upgrade select none
 d-i pkgsel/ignore-incomplete-language-support boolean true
-d-i pkgsel/include string %s
+d-i pkgsel/include string {{ join (fields .Host.Packages) " " }}
 
 # Language pack selection
 d-i pkgsel/install-language-support boolean false
@@ -253,56 +213,30 @@ d-i pkgsel/language-packs multiselect
 # "landscape" (manage system with Landscape).
 d-i pkgsel/update-policy select unattended-upgrades
 d-i pkgsel/updatedb boolean false
-`
 
-const preseedCmd = `
+### Account setup
+d-i passwd/root-login boolean false
+d-i passwd/make-user boolean true
+d-i passwd/user-fullname string {{ .Host.Username }}
+d-i passwd/username string {{ .Host.Username }}
+
+d-i passwd/user-password password {{ .Host.Password }}
+d-i passwd/user-password-again password {{ .Host.Password }}
+d-i user-setup/allow-password-weak boolean true
+d-i user-setup/encrypt-home boolean false
+
 d-i preseed/late_command string \
-    in-target sed -i 's/^%%sudo.*$/%%sudo ALL=(ALL:ALL) NOPASSWD: ALL/g' /etc/sudoers; \
+    in-target sed -i 's/^%sudo.*$/%sudo ALL=(ALL:ALL) NOPASSWD: ALL/g' /etc/sudoers; \
     in-target /bin/sh -c "echo 'Defaults env_keep += \"SSH_AUTH_SOCK\" >> /etc/sudoers"; \
-    in-target mkdir -p /home/%s/.ssh; \
-    in-target /bin/sh -c "echo '%s' >> /home/%s/.ssh/authorized_keys"; \
-    in-target chown -R %s:%s /home/%s/; \
-	in-target chmod -R go-rwx /home/%s/.ssh/authorized_keys; \
-	in-target sudo sed -i '/ swap / s/^/#/' /etc/fstab
+    in-target mkdir -p /home/{{ .Host.Username }}/.ssh; \
+    in-target /bin/sh -c "echo '{{ .SSHKey }}' >> /home/{{ .Host.Username }}/.ssh/authorized_keys"; \
+    in-target chown -R {{ .Host.Username }}:{{ .Host.Username }} /home/{{ .Host.Username }}/; \
+	in-target chmod -R go-rwx /home/{{ .Host.Username }}/.ssh/authorized_keys; \
+	in-target sudo sed -i '/ swap / s/^/#/' /etc/fstab{{ with .CompleteURL }}; \
+    wget -q -O /dev/null --post-data=installed {{ . }}{{ end }}
 `
 
 //BuildPreeSeedConfig - Creates a new presseed configuration using the passed data
 func (config *HostConfig) BuildPreeSeedConfig() string {
-
-	var key []byte
-	var err error
-
-	// Check the key has been populated
-	if config.SSHKey == "" {
-		log.Errorf("This server [%s] is being deployed with no SSH Key", config.ServerName)
-	} else {
-		// Decode the base64 into the SSH key
-		key, err = base64.StdEncoding.DecodeString(config.SSHKey)
-		if err != nil {
-			log.Errorf(err.Error())
-		}
-	}
-
-	var parsedDisk string
-
-	if *config.LVMEnable {
-		// We're using LVM, check if swap should be disabled or not
-		if *config.SwapDisabled {
-			parsedDisk = preseedLVMDisk + preseedLVMDiskRecipe2 + preseedLVMDiskDisableSwap
-		} else {
-			parsedDisk = preseedLVMDisk + preseedLVMDiskRecipe
-		}
-	} else {
-		if *config.SwapDisabled {
-			parsedDisk = preseedDisk + noswap
-		} else {
-			parsedDisk = preseedDisk + swap
-		}
-	}
-
-	parsedNet := fmt.Sprintf(preseedNet, config.Adapter, config.Gateway, config.IPAddress, config.NameServer, config.Subnet, config.ServerName)
-	parsedPkg := fmt.Sprintf(preseedPkg, config.RepositoryAddress, config.MirrorDirectory, config.RepositoryAddress, config.MirrorDirectory, config.Packages)
-	parsedCmd := fmt.Sprintf(preseedCmd, config.Username, key, config.Username, config.Username, config.Username, config.Username, config.Username)
-	parsedUsr := fmt.Sprintf(preseedUsers, config.Username, config.Username, config.Password, config.Password)
-	return fmt.Sprintf("%s%s%s%s%s%s", preseedHead, parsedDisk, parsedNet, parsedPkg, parsedUsr, parsedCmd)
+	return config.buildDefaultInstallerConfig("preseed")
 }
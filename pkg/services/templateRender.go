@@ -0,0 +1,255 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// InstallerTemplateData - is passed to an installer template (e.g. a preseed or kickstart) when it is rendered
+type InstallerTemplateData struct {
+	MAC           string // MAC address of the host e.g. 00:11:22:33:44:55
+	DashMAC       string // MAC address as it appears in URLs e.g. 00-11-22-33-44-55
+	HTTPAddress   string // Address of the plunder HTTP server
+	InstallingURL string // URL that is called once the installation has started
+	CompleteURL   string // URL that is called once the installation has finished, so that the host boots from its disk
+	FailedURL     string // URL that is called if the installation fails
+
+	Boot BootConfig // The boot configuration of the host
+	Host HostConfig // The configuration of the host
+
+	SSHKey        string   // The decoded SSH public key of the host
+	NetworkConfig string   // The (version 2) network configuration of the host, as YAML
+	AddressCIDR   string   // The address of the host with the prefix length of its subnet e.g. 192.168.0.2/24
+	NameServers   []string // The name servers of the host
+
+	Firmware string // The firmware [efi/bios] that the configuration is rendered for (windows only)
+}
+
+// installerTemplateFuncs are the functions available to the installer templates (in addition to the text/template builtins)
+var installerTemplateFuncs = template.FuncMap{
+	// enabled returns the value of an optional setting (e.g. lvmEnabled) where unset is false
+	"enabled": func(b *bool) bool {
+		return b != nil && *b
+	},
+	// default returns the value, unless it is blank in which case the default is returned
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	// fields splits a space or comma separated list (e.g. packages)
+	"fields": func(s string) []string {
+		return strings.Fields(strings.Replace(s, ",", " ", -1))
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	// add returns the sum of two numbers e.g. to number a list from 1
+	"add": func(a, b int) int {
+		return a + b
+	},
+	// hasPrefix reports whether a string begins with the prefix e.g. a crypted password begins with $
+	"hasPrefix": func(prefix, s string) bool {
+		return strings.HasPrefix(s, prefix)
+	},
+	// trimPrefix removes a prefix from a string e.g. pattern: from a package
+	"trimPrefix": func(prefix, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	// indent will indent every line of a (multi-line) string e.g. to nest YAML
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(strings.TrimRight(s, "\n"), "\n", "\n"+pad, -1)
+	},
+	// xml escapes a string so that it can be used as XML text or an attribute value
+	"xml": func(s string) string {
+		var buffer bytes.Buffer
+		xml.EscapeText(&buffer, []byte(s))
+		return buffer.String()
+	},
+	// quote returns a double quoted string that is safe to use as a YAML (or JSON) value
+	"quote": func(s string) string {
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(s)
+		return strings.TrimSuffix(buffer.String(), "\n")
+	},
+}
+
+// installerTemplates are the built-in installer templates, indexed by the configType
+var installerTemplates = map[string]*template.Template{}
+
+// defaultInstallerTemplates is the source of the built-in installer templates, indexed by the configType
+var defaultInstallerTemplates = map[string]string{
+	"preseed":     preseedTemplate,
+	"kickstart":   kickstartTemplate,
+	"vsphere":     kickstartESXiTemplate,
+	"cloudinit":   cloudInitUserDataTemplate,
+	"autoinstall": autoinstallTemplate,
+	"windows":     autounattendTemplate,
+	"autoyast":    autoyastTemplate,
+}
+
+// exampleInstallerTemplateData is used to validate templates before they are used
+var exampleInstallerTemplateData = InstallerTemplateData{
+	MAC:           "00:11:22:33:44:55",
+	DashMAC:       "00-11-22-33-44-55",
+	HTTPAddress:   "192.168.0.1",
+	InstallingURL: "http://192.168.0.1/installing/00-11-22-33-44-55",
+	CompleteURL:   "http://192.168.0.1/complete/00-11-22-33-44-55",
+	FailedURL:     "http://192.168.0.1/failed/00-11-22-33-44-55",
+	Host: HostConfig{
+		Adapter:           "ens192",
+		IPAddress:         "192.168.0.2",
+		ServerName:        "server01",
+		Gateway:           "192.168.0.1",
+		Subnet:            "255.255.255.0",
+		NameServer:        "192.168.0.1",
+		NTPServer:         "192.168.0.1",
+		Username:          "user",
+		Password:          "pass",
+		RepositoryAddress: "192.168.0.1",
+		MirrorDirectory:   "/ubuntu",
+		Packages:          "openssh-server",
+	},
+	SSHKey:        "ssh-rsa AABBCCDDEE1122334455",
+	NetworkConfig: "version: 2\n",
+	AddressCIDR:   "192.168.0.2/24",
+	NameServers:   []string{"192.168.0.1"},
+	Firmware:      "efi",
+}
+
+func init() {
+	// The built-in templates are validated when plunder starts, so that a broken default can never be shipped
+	for configType, t := range defaultInstallerTemplates {
+		tmpl, err := parseInstallerTemplate(configType, t)
+		if err != nil {
+			panic(err)
+		}
+		installerTemplates[configType] = tmpl
+	}
+}
+
+// DefaultInstallerTemplate - returns the built-in installer template for a configType
+func DefaultInstallerTemplate(configType string) (string, error) {
+	t, ok := defaultInstallerTemplates[configType]
+	if !ok {
+		return "", fmt.Errorf("No built-in installer template for config type [%s]", configType)
+	}
+	return t, nil
+}
+
+// parseInstallerTemplate will parse an installer template, and ensure that it renders with example data
+func parseInstallerTemplate(name, t string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(installerTemplateFuncs).Option("missingkey=error").Parse(t)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse installer template [%s] -> %v", name, err)
+	}
+	err = tmpl.Execute(ioutil.Discard, exampleInstallerTemplateData)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to render installer template [%s] -> %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// hasInstallerTemplate reports whether a boot configuration references its own installer template
+func (b *BootConfig) hasInstallerTemplate() bool {
+	return b.InstallerTemplate != "" || b.InstallerTemplatePath != ""
+}
+
+// installerTemplate returns the installer template for a boot configuration, this is either the template that it
+// references or the built-in template for its configType. A nil template means the configType has no installer.
+func (b *BootConfig) installerTemplate() (*template.Template, error) {
+	if b.InstallerTemplate != "" && b.InstallerTemplatePath != "" {
+		return nil, fmt.Errorf("Boot Config [%s] has both an installerTemplate and an installerTemplatePath", b.ConfigName)
+	}
+	if b.InstallerTemplatePath != "" {
+		t, err := ioutil.ReadFile(b.InstallerTemplatePath)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read installer template [%s] -> %v", b.InstallerTemplatePath, err)
+		}
+		return parseInstallerTemplate(b.InstallerTemplatePath, string(t))
+	}
+	if b.InstallerTemplate != "" {
+		return parseInstallerTemplate(b.ConfigName, b.InstallerTemplate)
+	}
+	return installerTemplates[b.ConfigType], nil
+}
+
+// buildInstallerConfig will render the installer configuration (preseed/kickstart) for a deployment
+func (b *BootConfig) buildInstallerConfig(deployment DeploymentConfig) (string, error) {
+	tmpl, err := b.installerTemplate()
+	if err != nil {
+		return "", err
+	}
+	if tmpl == nil {
+		return "", fmt.Errorf("Boot Config [%s] of type [%s] has no installer template", b.ConfigName, b.ConfigType)
+	}
+	return renderInstallerTemplate(tmpl, newInstallerTemplateData(deployment.MAC, *b, deployment.ConfigHost))
+}
+
+// newInstallerTemplateData creates the data passed to an installer template for a host
+func newInstallerTemplateData(mac string, boot BootConfig, host HostConfig) InstallerTemplateData {
+	data := InstallerTemplateData{
+		MAC:           mac,
+		DashMAC:       strings.Replace(mac, ":", "-", -1),
+		HTTPAddress:   HttpAddress,
+		Boot:          boot,
+		Host:          host,
+		SSHKey:        host.publicKey(),
+		NetworkConfig: host.BuildCloudInitNetworkConfig(mac),
+		AddressCIDR:   host.addressCIDR(),
+		NameServers:   host.nameServers(),
+	}
+	// The installer callback URLs are specific to a host
+	if mac != "" {
+		data.InstallingURL = installingURL(data.DashMAC)
+		data.CompleteURL = completeURL(data.DashMAC)
+		data.FailedURL = failedURL(data.DashMAC)
+	}
+	return data
+}
+
+// installingURL is the URL an installer POSTs to once it has started, the host is identified by the URL rather than the
+// address it connects from (which may be translated, or shared by the hosts using an address pool)
+func installingURL(dashMac string) string {
+	return fmt.Sprintf("http://%s/installing/%s", HttpAddress, dashMac)
+}
+
+// completeURL is the URL an installer POSTs to once it has finished, so that the host boots from its disk
+func completeURL(dashMac string) string {
+	return fmt.Sprintf("http://%s/complete/%s", HttpAddress, dashMac)
+}
+
+// failedURL is the URL an installer POSTs to if the installation fails
+func failedURL(dashMac string) string {
+	return fmt.Sprintf("http://%s/failed/%s", HttpAddress, dashMac)
+}
+
+// renderInstallerTemplate executes an installer template
+func renderInstallerTemplate(tmpl *template.Template, data InstallerTemplateData) (string, error) {
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", fmt.Errorf("Unable to render installer template [%s] -> %v", tmpl.Name(), err)
+	}
+	return buffer.String(), nil
+}
+
+// buildDefaultInstallerConfig renders the built-in installer template of a configType for a host
+func (config *HostConfig) buildDefaultInstallerConfig(configType string) string {
+	data := newInstallerTemplateData("", BootConfig{ConfigType: configType}, *config)
+	installerConfig, err := renderInstallerTemplate(installerTemplates[configType], data)
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	return installerConfig
+}
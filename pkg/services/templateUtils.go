@@ -2,7 +2,9 @@ package services
 
 import (
 	"encoding/base64"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"strings"
 )
@@ -39,6 +41,31 @@ func (c *HostConfig) parseSSH() error {
 	return nil
 }
 
+// publicKey returns the (decoded) SSH public key of a host, the key is typically base64 encoded when it has been read
+// from the sshkeypath
+func (c *HostConfig) publicKey() string {
+	if key, err := base64.StdEncoding.DecodeString(c.SSHKey); err == nil {
+		return strings.TrimRight(string(key), "\r\n")
+	}
+	return c.SSHKey
+}
+
+// addressCIDR returns the address of a host with the prefix length of its subnet e.g. 192.168.0.2/24
+func (c *HostConfig) addressCIDR() string {
+	// The subnet is a netmask e.g. 255.255.255.0, which is converted to a prefix length
+	if mask := net.ParseIP(c.Subnet).To4(); mask != nil {
+		if ones, bits := net.IPMask(mask).Size(); bits != 0 {
+			return fmt.Sprintf("%s/%d", c.IPAddress, ones)
+		}
+	}
+	return c.IPAddress
+}
+
+// nameServers returns the name servers of a host, multiple servers are comma or space separated
+func (c *HostConfig) nameServers() []string {
+	return strings.Fields(strings.Replace(c.NameServer, ",", " ", -1))
+}
+
 // PopulateFromGlobalConfiguration - This will read a deployment configuration and attempt to fill any missing fields from the global config
 func (c *HostConfig) PopulateFromGlobalConfiguration(globalConfig HostConfig) {
 	// NETWORK CONFIGURATION
@@ -58,6 +85,11 @@ func (c *HostConfig) PopulateFromGlobalConfiguration(globalConfig HostConfig) {
 		c.NameServer = globalConfig.NameServer
 	}
 
+	// Inherit the global Time Server
+	if c.NTPServer == "" {
+		c.NTPServer = globalConfig.NTPServer
+	}
+
 	if c.Adapter == "" {
 		c.Adapter = globalConfig.Adapter
 	}
@@ -102,6 +134,11 @@ func (c *HostConfig) PopulateFromGlobalConfiguration(globalConfig HostConfig) {
 		c.Password = globalConfig.Password
 	}
 
+	// Inherit the global SSH Key (unless this host has its own key file)
+	if c.SSHKey == "" && c.SSHKeyPath == "" {
+		c.SSHKey = globalConfig.SSHKey
+	}
+
 	// Inherit the global SSH Key Path
 	if c.SSHKeyPath == "" {
 		c.SSHKeyPath = globalConfig.SSHKeyPath
@@ -154,4 +191,60 @@ func (c *HostConfig) PopulateFromGlobalConfiguration(globalConfig HostConfig) {
 	if c.ShellOnFail == nil && globalConfig.ShellOnFail != nil {
 		c.ShellOnFail = globalConfig.ShellOnFail
 	}
+
+	// Inherit the global systemd units
+	if len(c.SystemdUnits) == 0 {
+		c.SystemdUnits = globalConfig.SystemdUnits
+	}
+
+	// Windows configuration
+
+	if c.ProductKey == "" {
+		c.ProductKey = globalConfig.ProductKey
+	}
+
+	if c.WindowsImage == "" {
+		c.WindowsImage = globalConfig.WindowsImage
+	}
+
+	// The share credentials are only inherited along with the share
+	if c.InstallShare == "" {
+		c.InstallShare = globalConfig.InstallShare
+		c.InstallShareUsername = globalConfig.InstallShareUsername
+		c.InstallSharePassword = globalConfig.InstallSharePassword
+	}
+
+	// VMware ESXi configuration
+
+	if c.VLANID == 0 {
+		c.VLANID = globalConfig.VLANID
+	}
+
+	if c.InstallDiskModel == "" {
+		c.InstallDiskModel = globalConfig.InstallDiskModel
+	}
+
+	if c.InstallDiskMinSize == 0 {
+		c.InstallDiskMinSize = globalConfig.InstallDiskMinSize
+	}
+
+	if c.DatastoreName == "" {
+		c.DatastoreName = globalConfig.DatastoreName
+	}
+
+	if c.SSHDisabled == nil && globalConfig.SSHDisabled != nil {
+		c.SSHDisabled = globalConfig.SSHDisabled
+	}
+
+	if c.ShellDisabled == nil && globalConfig.ShellDisabled != nil {
+		c.ShellDisabled = globalConfig.ShellDisabled
+	}
+
+	if c.IPv6Enabled == nil && globalConfig.IPv6Enabled != nil {
+		c.IPv6Enabled = globalConfig.IPv6Enabled
+	}
+
+	if len(c.FirstbootCommands) == 0 {
+		c.FirstbootCommands = globalConfig.FirstbootCommands
+	}
 }
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The files that are passed to wimboot for a windows deployment, wimboot adds them to X:\Windows\System32 of WinPE.
+// These are served from a directory named after the mac address of a server e.g. /00-11-22-33-44-55/install.cmd
+var windowsFiles = map[string]bool{
+	"autounattend.xml":      true,
+	"autounattend-bios.xml": true,
+	"winpeshl.ini":          true,
+	"install.cmd":           true,
+}
+
+// windowsUnattendFiles are the answer files that are rendered for each firmware, as the disk layout differs. The iPXE
+// script passes the answer file for the firmware of the server to WinPE as autounattend.xml
+var windowsUnattendFiles = map[string]string{
+	"autounattend.xml":      "efi",
+	"autounattend-bios.xml": "bios",
+}
+
+// windowsWinPEShl replaces the WinPE shell, so that the installation is started once WinPE has initialised
+const windowsWinPEShl = "[LaunchApps]\r\n" +
+	"%SYSTEMROOT%\\System32\\wpeinit.exe\r\n" +
+	"%SYSTEMROOT%\\System32\\cmd.exe, /c %SYSTEMROOT%\\System32\\install.cmd\r\n"
+
+// autounattendTemplate is the built-in Windows Setup answer file, it can be replaced by setting the installerTemplate or
+// installerTemplatePath of a boot configuration. OpenSSH is installed when the Administrator is first (automatically)
+// logged on, as the capability can't be added whilst Setup is running.
+const autounattendTemplate = `<?xml version="1.0" encoding="utf-8"?>
+<unattend xmlns="urn:schemas-microsoft-com:unattend" xmlns:wcm="http://schemas.microsoft.com/WMIConfig/2002/State">
+  <settings pass="windowsPE">
+    <component name="Microsoft-Windows-International-Core-WinPE" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <SetupUILanguage>
+        <UILanguage>en-US</UILanguage>
+      </SetupUILanguage>
+      <InputLocale>en-US</InputLocale>
+      <SystemLocale>en-US</SystemLocale>
+      <UILanguage>en-US</UILanguage>
+      <UserLocale>en-US</UserLocale>
+    </component>
+    <component name="Microsoft-Windows-Setup" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <DiskConfiguration>
+        <Disk wcm:action="add">
+          <DiskID>0</DiskID>
+          <WillWipeDisk>true</WillWipeDisk>
+          <CreatePartitions>
+{{- if eq .Firmware "bios" }}
+            <CreatePartition wcm:action="add">
+              <Order>1</Order>
+              <Type>Primary</Type>
+              <Size>500</Size>
+            </CreatePartition>
+            <CreatePartition wcm:action="add">
+              <Order>2</Order>
+              <Type>Primary</Type>
+              <Extend>true</Extend>
+            </CreatePartition>
+{{- else }}
+            <CreatePartition wcm:action="add">
+              <Order>1</Order>
+              <Type>EFI</Type>
+              <Size>100</Size>
+            </CreatePartition>
+            <CreatePartition wcm:action="add">
+              <Order>2</Order>
+              <Type>MSR</Type>
+              <Size>16</Size>
+            </CreatePartition>
+            <CreatePartition wcm:action="add">
+              <Order>3</Order>
+              <Type>Primary</Type>
+              <Extend>true</Extend>
+            </CreatePartition>
+{{- end }}
+          </CreatePartitions>
+          <ModifyPartitions>
+{{- if eq .Firmware "bios" }}
+            <ModifyPartition wcm:action="add">
+              <Order>1</Order>
+              <PartitionID>1</PartitionID>
+              <Format>NTFS</Format>
+              <Label>System</Label>
+              <Active>true</Active>
+            </ModifyPartition>
+            <ModifyPartition wcm:action="add">
+              <Order>2</Order>
+              <PartitionID>2</PartitionID>
+              <Format>NTFS</Format>
+              <Label>Windows</Label>
+              <Letter>C</Letter>
+            </ModifyPartition>
+{{- else }}
+            <ModifyPartition wcm:action="add">
+              <Order>1</Order>
+              <PartitionID>1</PartitionID>
+              <Format>FAT32</Format>
+              <Label>System</Label>
+            </ModifyPartition>
+            <ModifyPartition wcm:action="add">
+              <Order>2</Order>
+              <PartitionID>3</PartitionID>
+              <Format>NTFS</Format>
+              <Label>Windows</Label>
+              <Letter>C</Letter>
+            </ModifyPartition>
+{{- end }}
+          </ModifyPartitions>
+        </Disk>
+      </DiskConfiguration>
+      <ImageInstall>
+        <OSImage>
+          <InstallFrom>
+            <MetaData wcm:action="add">
+{{- if .Host.WindowsImage }}
+              <Key>/IMAGE/NAME</Key>
+              <Value>{{ xml .Host.WindowsImage }}</Value>
+{{- else }}
+              <Key>/IMAGE/INDEX</Key>
+              <Value>1</Value>
+{{- end }}
+            </MetaData>
+          </InstallFrom>
+          <InstallTo>
+            <DiskID>0</DiskID>
+            <PartitionID>{{ if eq .Firmware "bios" }}2{{ else }}3{{ end }}</PartitionID>
+          </InstallTo>
+        </OSImage>
+      </ImageInstall>
+      <UserData>
+        <AcceptEula>true</AcceptEula>
+{{- if .Host.ProductKey }}
+        <ProductKey>
+          <Key>{{ xml .Host.ProductKey }}</Key>
+          <WillShowUI>OnError</WillShowUI>
+        </ProductKey>
+{{- end }}
+      </UserData>
+    </component>
+  </settings>
+  <settings pass="specialize">
+    <component name="Microsoft-Windows-Shell-Setup" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <ComputerName>{{ if .Host.ServerName }}{{ xml .Host.ServerName }}{{ else }}*{{ end }}</ComputerName>
+      <TimeZone>UTC</TimeZone>
+    </component>
+{{- if .Host.IPAddress }}
+    <component name="Microsoft-Windows-TCPIP" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <Interfaces>
+        <Interface wcm:action="add">
+          <Identifier>{{ upper .DashMAC }}</Identifier>
+          <Ipv4Settings>
+            <DhcpEnabled>false</DhcpEnabled>
+          </Ipv4Settings>
+          <UnicastIpAddresses>
+            <IpAddress wcm:action="add" wcm:keyValue="1">{{ xml .AddressCIDR }}</IpAddress>
+          </UnicastIpAddresses>
+{{- if .Host.Gateway }}
+          <Routes>
+            <Route wcm:action="add">
+              <Identifier>0</Identifier>
+              <Prefix>0.0.0.0/0</Prefix>
+              <NextHopAddress>{{ xml .Host.Gateway }}</NextHopAddress>
+            </Route>
+          </Routes>
+{{- end }}
+        </Interface>
+      </Interfaces>
+    </component>
+{{- if .NameServers }}
+    <component name="Microsoft-Windows-DNS-Client" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <Interfaces>
+        <Interface wcm:action="add">
+          <Identifier>{{ upper .DashMAC }}</Identifier>
+          <DNSServerSearchOrder>
+{{- range $index, $nameserver := .NameServers }}
+            <IpAddress wcm:action="add" wcm:keyValue="{{ add $index 1 }}">{{ xml $nameserver }}</IpAddress>
+{{- end }}
+          </DNSServerSearchOrder>
+        </Interface>
+      </Interfaces>
+    </component>
+{{- end }}
+{{- end }}
+  </settings>
+  <settings pass="oobeSystem">
+    <component name="Microsoft-Windows-Shell-Setup" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <UserAccounts>
+        <AdministratorPassword>
+          <Value>{{ xml .Host.Password }}</Value>
+          <PlainText>true</PlainText>
+        </AdministratorPassword>
+{{- if and .Host.Username (ne .Host.Username "Administrator") }}
+        <LocalAccounts>
+          <LocalAccount wcm:action="add">
+            <Name>{{ xml .Host.Username }}</Name>
+            <Group>Administrators</Group>
+            <Password>
+              <Value>{{ xml .Host.Password }}</Value>
+              <PlainText>true</PlainText>
+            </Password>
+          </LocalAccount>
+        </LocalAccounts>
+{{- end }}
+      </UserAccounts>
+      <AutoLogon>
+        <Enabled>true</Enabled>
+        <Username>Administrator</Username>
+        <Password>
+          <Value>{{ xml .Host.Password }}</Value>
+          <PlainText>true</PlainText>
+        </Password>
+        <LogonCount>1</LogonCount>
+      </AutoLogon>
+      <OOBE>
+        <HideEULAPage>true</HideEULAPage>
+        <HideLocalAccountScreen>true</HideLocalAccountScreen>
+        <HideOnlineAccountScreens>true</HideOnlineAccountScreens>
+        <HideWirelessSetupInOOBE>true</HideWirelessSetupInOOBE>
+        <NetworkLocation>Work</NetworkLocation>
+        <ProtectYourPC>3</ProtectYourPC>
+      </OOBE>
+      <FirstLogonCommands>
+        <SynchronousCommand wcm:action="add">
+          <Order>1</Order>
+          <Description>Install the OpenSSH server</Description>
+          <CommandLine>powershell.exe -NoProfile -ExecutionPolicy Bypass -Command "Add-WindowsCapability -Online -Name OpenSSH.Server~~~~0.0.1.0; Set-Service -Name sshd -StartupType Automatic; Start-Service -Name sshd"</CommandLine>
+        </SynchronousCommand>
+{{- if .SSHKey }}
+        <SynchronousCommand wcm:action="add">
+          <Order>2</Order>
+          <Description>Authorize the SSH key for administrators</Description>
+          <CommandLine>powershell.exe -NoProfile -ExecutionPolicy Bypass -Command "Set-Content -Path $env:ProgramData\ssh\administrators_authorized_keys -Value '{{ xml .SSHKey }}'; icacls.exe $env:ProgramData\ssh\administrators_authorized_keys /inheritance:r /grant Administrators:F /grant SYSTEM:F"</CommandLine>
+        </SynchronousCommand>
+{{- end }}
+{{- if .Host.NTPServer }}
+        <SynchronousCommand wcm:action="add">
+          <Order>{{ if .SSHKey }}3{{ else }}2{{ end }}</Order>
+          <Description>Configure the time server</Description>
+          <CommandLine>w32tm.exe /config /manualpeerlist:{{ xml .Host.NTPServer }} /syncfromflags:manual /update</CommandLine>
+        </SynchronousCommand>
+{{- end }}
+      </FirstLogonCommands>
+    </component>
+  </settings>
+</unattend>
+`
+
+// buildWindowsConfig will render the files that are passed to WinPE for a deployment, indexed by filename
+func (b *BootConfig) buildWindowsConfig(deployment DeploymentConfig) (map[string]string, error) {
+	tmpl, err := b.installerTemplate()
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		return nil, fmt.Errorf("Boot Config [%s] of type [%s] has no installer template", b.ConfigName, b.ConfigType)
+	}
+
+	files := map[string]string{
+		"winpeshl.ini": windowsWinPEShl,
+		"install.cmd":  deployment.ConfigHost.BuildWindowsInstallScript(deployment.MAC),
+	}
+	for filename, firmware := range windowsUnattendFiles {
+		data := newInstallerTemplateData(deployment.MAC, *b, deployment.ConfigHost)
+		data.Firmware = firmware
+		files[filename], err = renderInstallerTemplate(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// BuildWindowsInstallScript - Creates the script that is run by WinPE, it mounts the install share (if there is one) and
+// starts Windows Setup with the answer file
+func (config *HostConfig) BuildWindowsInstallScript(mac string) string {
+	// Batch files expand any % in the credentials
+	escape := strings.NewReplacer("%", "%%", "\"", "")
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "@echo off\r\n")
+	fmt.Fprintf(&script, "rem Windows installation for [%s], created by plunder\r\n", mac)
+	fmt.Fprintf(&script, "wpeutil WaitForNetwork\r\n")
+
+	// Without a share the installation media must be attached to the server (e.g. virtual media)
+	setup := `X:\sources\setup.exe`
+	if config.InstallShare != "" {
+		fmt.Fprintf(&script, ":mount\r\n")
+		fmt.Fprintf(&script, "echo Mounting %s\r\n", config.InstallShare)
+		if config.InstallShareUsername != "" {
+			fmt.Fprintf(&script, "net use Z: \"%s\" \"%s\" /user:\"%s\"\r\n", config.InstallShare, escape.Replace(config.InstallSharePassword), escape.Replace(config.InstallShareUsername))
+		} else {
+			fmt.Fprintf(&script, "net use Z: \"%s\"\r\n", config.InstallShare)
+		}
+		// The network may not be ready, so keep retrying
+		fmt.Fprintf(&script, "if errorlevel 1 (\r\n  ping -n 5 127.0.0.1 > nul\r\n  goto mount\r\n)\r\n")
+		setup = `Z:\setup.exe`
+	}
+	fmt.Fprintf(&script, "%s /unattend:X:\\Windows\\System32\\autounattend.xml\r\n", setup)
+	return script.String()
+}
@@ -8,6 +8,7 @@ type BootController struct {
 
 	// Servers
 	EnableDHCP *bool `json:"enableDHCP"` // Enable Server
+	ProxyDHCP  *bool `json:"proxyDHCP"`  // Only provide PXE boot information, addresses come from an existing DHCP server
 	//DHCP Configuration
 	DHCPConfig dhcpConfig `json:"dhcpConfig,omitempty"`
 
@@ -18,21 +19,73 @@ type BootController struct {
 	HttpAddress *string `json:"addressHTTP"` // Should ideally be the IP of the adapter
 
 	// TFTP Configuration
-	PXEFileName *string `json:"pxePath"` // undionly.kpxe
+	PXEFileName      *string `json:"pxePath"`      // undionly.kpxe
+	PXEEFIFileName   *string `json:"pxeEFIPath"`   // ipxe.efi
+	PXEARM64FileName *string `json:"pxeARM64Path"` // snp.efi
+	TFTPRoot         *string `json:"rootTFTP"`     // Directory of additional files to serve over TFTP
+
+	// ISO catalog
+	ISODirectory *string `json:"isoDirectory"` // Directory that ISOs are uploaded to through the API
+
+	// Installed deployments
+	InstalledFile *string `json:"installedFile"` // Path that installed deployments are persisted to (blank disables persistence)
 
 	// Boot Configuration
 	BootConfigs []BootConfig `json:"bootConfigs"` // Array of kernel configurations
 
+	// Rules used to automatically create deployments for discovered servers
+	EnrolmentRules []EnrolmentRule `json:"enrolmentRules,omitempty"`
+
 	handler *DHCPSettings
 }
 
 type dhcpConfig struct {
-	DHCPAddress      string `json:"addressDHCP"`    // Should ideally be the IP of the adapter
-	DHCPStartAddress string `json:"startDHCP"`      // The first available DHCP address
-	DHCPLeasePool    int    `json:"leasePoolDHCP"`  // Size of the IP Address pool
-	DHCPSubnet       string `json:"subnetDHCP"`     // Subnet for leases
-	DHCPGateway      string `json:"gatewayDHCP"`    // Gateway to advertise
-	DHCPDNS          string `json:"nameserverDHCP"` // DNS server to advertise
+	DHCPAddress string `json:"addressDHCP"` // Should ideally be the IP of the adapter
+
+	// The scope for the network that the adapter is attached to
+	dhcpScope
+
+	DHCPLeaseFile string      `json:"leaseFileDHCP"`        // Path to the lease database (blank disables persistence)
+	DHCPScopes    []dhcpScope `json:"scopesDHCP,omitempty"` // Additional scopes for networks that are reached through a DHCP relay
+}
+
+type dhcpScope struct {
+	ScopeName        string `json:"scopeName,omitempty"` // Name used to identify the scope
+	DHCPStartAddress string `json:"startDHCP"`           // The first available DHCP address
+	DHCPLeasePool    int    `json:"leasePoolDHCP"`       // Size of the IP Address pool
+	DHCPSubnet       string `json:"subnetDHCP"`          // Subnet for leases
+	DHCPGateway      string `json:"gatewayDHCP"`         // Gateway to advertise
+	DHCPDNS          string `json:"nameserverDHCP"`      // DNS server to advertise
+
+	DHCPOptions []DHCPOption `json:"optionsDHCP,omitempty"` // Additional options to advertise
+}
+
+// DHCPOption defines an additional DHCP option that is handed to clients
+type DHCPOption struct {
+	Option string `json:"option"`         // Either the name of a well known option (e.g. ntpServers) or the option code
+	Type   string `json:"type,omitempty"` // How the value is encoded [string/ip/ips/uint8/uint16/uint32/bool/hex/domains]
+	Value  string `json:"value"`          // The value, lists are comma separated
+}
+
+// EnrolmentRule defines how a discovered server is matched and the deployment that will be created for it
+type EnrolmentRule struct {
+	RuleName string `json:"ruleName"`
+
+	// Matching (all of the specified criteria must match)
+	MACPrefix   string `json:"macPrefix,omitempty"`   // Beginning of the MAC address e.g. an OUI 00:50:56
+	VendorClass string `json:"vendorClass,omitempty"` // Beginning of the DHCP vendor class (option 60) e.g. PXEClient:Arch:00007
+	UserClass   string `json:"userClass,omitempty"`   // DHCP user class (option 77) e.g. iPXE
+	ClientArch  *int   `json:"clientArch,omitempty"`  // Client system architecture (option 93) e.g. 0 (BIOS) or 7 (x86_64 UEFI)
+
+	SystemUUIDs []string `json:"systemUUIDs,omitempty"` // SMBIOS system UUIDs (option 97) e.g. from a hardware inventory
+
+	// Deployment that is created
+	ConfigName      string     `json:"bootConfigName"`            // Boot configuration to assign
+	HostnamePattern string     `json:"hostnamePattern,omitempty"` // Hostname with a printf style index e.g. worker-%02d
+	AddressStart    string     `json:"addressStart,omitempty"`    // First address to assign, incremented for each server
+	IndexStart      int        `json:"indexStart,omitempty"`      // First index used in the hostname pattern
+	MaxHosts        int        `json:"maxHosts,omitempty"`        // Maximum number of deployments this rule creates (0 is unlimited)
+	ConfigHost      HostConfig `json:"config,omitempty"`          // Additional host configuration
 }
 
 // BootConfig defines a named configuration for booting
@@ -45,6 +98,14 @@ type BootConfig struct {
 	Initrd  string `json:"initrdPath"`
 	Cmdline string `json:"cmdline"`
 
+	// iPXE script template (text/template), if neither is set then the built-in script for the configType is used
+	IPXETemplate     string `json:"ipxeTemplate,omitempty"`     // Inline template
+	IPXETemplatePath string `json:"ipxeTemplatePath,omitempty"` // Path to a template file
+
+	// Installer (preseed/kickstart/vSphere kickstart) template, if neither is set then the built-in template is used
+	InstallerTemplate     string `json:"installerTemplate,omitempty"`     // Inline template
+	InstallerTemplatePath string `json:"installerTemplatePath,omitempty"` // Path to a template file
+
 	// ISO Reader settings
 	ISOPath   string `json:"isoPath,omitempty"`
 	ISOPrefix string `json:"isoPrefix,omitempty"`
@@ -62,6 +123,17 @@ type DeploymentConfig struct {
 	ConfigName string     `json:"bootConfigName,omitempty"` // To be discovered in the controller BootConfig array
 	ConfigBoot BootConfig `json:"bootConfig,omitempty"`     // Array of kernel configurations
 	ConfigHost HostConfig `json:"config"`
+
+	EnrolmentRule string `json:"enrolmentRule,omitempty"` // The enrolment rule that created this deployment
+
+	DHCPOptions []DHCPOption `json:"dhcpOptions,omitempty"` // Additional options to advertise to this host
+
+	// Installed is set when the installer calls the completion endpoint, the server then boots from its local disk
+	// until it is re-provisioned
+	Installed bool `json:"installed,omitempty"`
+
+	// State is the provisioning state of the server, it is managed by plunder and only returned through the API
+	State *DeploymentState `json:"state,omitempty"`
 }
 
 // HostConfig - Defines how a server will be configured by plunder
@@ -117,4 +189,31 @@ type HostConfig struct {
 
 	// Troubleshooting
 	ShellOnFail *bool `json:"shellOnFail,omitempty"`
+
+	// Systemd units to be written/enabled (Ignition)
+	SystemdUnits []SystemdUnit `json:"systemdUnits,omitempty"`
+
+	// Windows deployment
+	ProductKey           string `json:"productKey,omitempty"`           // Product key, blank for evaluation or KMS media
+	WindowsImage         string `json:"windowsImage,omitempty"`         // Name of the image in install.wim e.g. Windows Server 2022 SERVERSTANDARD
+	InstallShare         string `json:"installShare,omitempty"`         // SMB share with the contents of the ISO e.g. \\192.168.0.1\win2022
+	InstallShareUsername string `json:"installShareUsername,omitempty"` // User that the share is mounted with
+	InstallSharePassword string `json:"installSharePassword,omitempty"` // Password that the share is mounted with
+
+	// VMware ESXi deployment (the disk is selected by the destinationDevice, installDiskMinSize and then installDiskModel)
+	VLANID             int      `json:"vlanId,omitempty"`             // VLAN of the management network
+	InstallDiskModel   string   `json:"installDiskModel,omitempty"`   // Models, vendors or drivers of the disk to install to e.g. local or ST3120814A
+	InstallDiskMinSize int      `json:"installDiskMinSize,omitempty"` // Size (GB) that the disk to install to must be at least
+	DatastoreName      string   `json:"datastoreName,omitempty"`      // Name of the local datastore (datastore1)
+	SSHDisabled        *bool    `json:"sshDisabled,omitempty"`        // Don't enable SSH
+	ShellDisabled      *bool    `json:"shellDisabled,omitempty"`      // Don't enable the ESXi Shell
+	IPv6Enabled        *bool    `json:"ipv6Enabled,omitempty"`        // Leave IPv6 enabled
+	FirstbootCommands  []string `json:"firstbootCommands,omitempty"`  // Additional commands that are run on the first boot
+}
+
+// SystemdUnit - Defines a systemd unit that is configured on a server
+type SystemdUnit struct {
+	Name     string `json:"name"`               // Name of the unit e.g. docker.service
+	Enabled  *bool  `json:"enabled,omitempty"`  // Enable (or disable) the unit
+	Contents string `json:"contents,omitempty"` // Contents of the unit, if blank then an existing unit is configured
 }
@@ -0,0 +1,140 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TFTP opcodes (RFC 1350 and RFC 2347)
+const (
+	opRRQ   uint16 = 1
+	opWRQ   uint16 = 2
+	opDATA  uint16 = 3
+	opACK   uint16 = 4
+	opERROR uint16 = 5
+	opOACK  uint16 = 6
+)
+
+// TFTP error codes
+const (
+	errNotDefined       uint16 = 0
+	errFileNotFound     uint16 = 1
+	errAccessViolation  uint16 = 2
+	errIllegalOperation uint16 = 4
+	errOptionRefused    uint16 = 8 // RFC 2347, the client is terminating the transfer after the option acknowledgement
+)
+
+// errPacket is returned when a packet can't be parsed
+var errPacket = errors.New("Malformed TFTP packet")
+
+// clientError is an error packet that has been sent by a client
+type clientError struct {
+	code    uint16
+	message string
+}
+
+func (e *clientError) Error() string {
+	return fmt.Sprintf("Client error [%d] %s", e.code, e.message)
+}
+
+// request is a parsed read or write request
+type request struct {
+	opcode   uint16
+	filename string
+	mode     string
+	options  map[string]string
+	// order keeps the options in the order the client sent them, the OACK should follow the same order
+	order []string
+}
+
+// parseRequest will parse a RRQ/WRQ packet, along with any options (RFC 2347)
+func parseRequest(b []byte) (*request, error) {
+	if len(b) < 4 {
+		return nil, errPacket
+	}
+	r := &request{
+		opcode:  binary.BigEndian.Uint16(b),
+		options: map[string]string{},
+	}
+	if r.opcode != opRRQ && r.opcode != opWRQ {
+		return nil, fmt.Errorf("Unexpected TFTP opcode [%d] for a request", r.opcode)
+	}
+
+	// The remainder of the packet is a list of null terminated strings
+	fields := bytes.Split(b[2:], []byte{0})
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, errPacket
+	}
+	fields = fields[:len(fields)-1]
+
+	r.filename = string(fields[0])
+	r.mode = strings.ToLower(string(fields[1]))
+	if r.filename == "" {
+		return nil, errPacket
+	}
+
+	// Options are pairs of name and value, a trailing unpaired option is ignored
+	for i := 2; i+1 < len(fields); i += 2 {
+		name := strings.ToLower(string(fields[i]))
+		if _, ok := r.options[name]; !ok {
+			r.order = append(r.order, name)
+		}
+		r.options[name] = string(fields[i+1])
+	}
+	return r, nil
+}
+
+// dataPacket builds a DATA packet
+func dataPacket(block uint16, data []byte) []byte {
+	b := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint16(b, opDATA)
+	binary.BigEndian.PutUint16(b[2:], block)
+	copy(b[4:], data)
+	return b
+}
+
+// ackPacket builds an ACK packet
+func ackPacket(block uint16) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, opACK)
+	binary.BigEndian.PutUint16(b[2:], block)
+	return b
+}
+
+// errorPacket builds an ERROR packet
+func errorPacket(code uint16, message string) []byte {
+	b := make([]byte, 4, 5+len(message))
+	binary.BigEndian.PutUint16(b, opERROR)
+	binary.BigEndian.PutUint16(b[2:], code)
+	b = append(b, message...)
+	return append(b, 0)
+}
+
+// oackPacket builds an OACK packet from the accepted options, in the order they were requested
+func oackPacket(order []string, options map[string]string) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, opOACK)
+	for _, name := range order {
+		value, ok := options[name]
+		if !ok {
+			continue
+		}
+		b = append(b, name...)
+		b = append(b, 0)
+		b = append(b, value...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+// parseReply parses an ACK, DATA or ERROR packet from a client returning the opcode, block number (or error code) and
+// any payload (data or error message)
+func parseReply(b []byte) (uint16, uint16, []byte, error) {
+	if len(b) < 4 {
+		return 0, 0, nil, errPacket
+	}
+	return binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:]), b[4:], nil
+}
@@ -0,0 +1,123 @@
+package tftp
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// testRequest builds a request packet from the opcode and a list of fields, each field is null terminated
+func testRequest(opcode uint16, fields ...string) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, opcode)
+	for _, field := range fields {
+		b = append(b, field...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		want    *request
+		wantErr bool
+	}{
+		{
+			name:   "read without options",
+			packet: testRequest(opRRQ, "undionly.kpxe", "octet"),
+			want:   &request{opcode: opRRQ, filename: "undionly.kpxe", mode: "octet", options: map[string]string{}},
+		},
+		{
+			name:   "write with the mode in upper case",
+			packet: testRequest(opWRQ, "upload.log", "OCTET"),
+			want:   &request{opcode: opWRQ, filename: "upload.log", mode: "octet", options: map[string]string{}},
+		},
+		{
+			name:   "options are lower cased and kept in order",
+			packet: testRequest(opRRQ, "ipxe.efi", "octet", "TSIZE", "0", "blksize", "1468", "windowSize", "16"),
+			want: &request{
+				opcode:   opRRQ,
+				filename: "ipxe.efi",
+				mode:     "octet",
+				options:  map[string]string{"tsize": "0", "blksize": "1468", "windowsize": "16"},
+				order:    []string{"tsize", "blksize", "windowsize"},
+			},
+		},
+		{
+			name:   "a repeated option keeps its position with the last value",
+			packet: testRequest(opRRQ, "ipxe.efi", "octet", "blksize", "512", "tsize", "0", "blksize", "1024"),
+			want: &request{
+				opcode:   opRRQ,
+				filename: "ipxe.efi",
+				mode:     "octet",
+				options:  map[string]string{"blksize": "1024", "tsize": "0"},
+				order:    []string{"blksize", "tsize"},
+			},
+		},
+		{
+			name:   "a trailing unpaired option is ignored",
+			packet: testRequest(opRRQ, "ipxe.efi", "octet", "tsize", "0", "blksize"),
+			want: &request{
+				opcode:   opRRQ,
+				filename: "ipxe.efi",
+				mode:     "octet",
+				options:  map[string]string{"tsize": "0"},
+				order:    []string{"tsize"},
+			},
+		},
+		{
+			name:    "too short",
+			packet:  []byte{0, 1, 'a'},
+			wantErr: true,
+		},
+		{
+			name:    "not a request",
+			packet:  testRequest(opACK, "ipxe.efi", "octet"),
+			wantErr: true,
+		},
+		{
+			name:    "missing the mode",
+			packet:  testRequest(opRRQ, "ipxe.efi"),
+			wantErr: true,
+		},
+		{
+			name:    "missing the final null",
+			packet:  append(testRequest(opRRQ, "ipxe.efi"), "octet"...),
+			wantErr: true,
+		},
+		{
+			name:    "empty filename",
+			packet:  testRequest(opRRQ, "", "octet"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRequest(tt.packet)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, parsed %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parsed %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOACKPacket(t *testing.T) {
+	// Only the accepted options are acknowledged, in the order the client requested them
+	got := oackPacket([]string{"tsize", "blksize", "windowsize"}, map[string]string{"windowsize": "8", "tsize": "1024"})
+	want := []byte("\x00\x06tsize\x001024\x00windowsize\x008\x00")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Built %q, expected %q", got, want)
+	}
+}
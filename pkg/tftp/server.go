@@ -0,0 +1,450 @@
+// Package tftp implements a TFTP server (RFC 1350) with support for option negotiation (RFC 2347), along with the
+// blksize (RFC 2348), tsize/timeout (RFC 2349) and windowsize (RFC 7440) options.
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultBlockSize is the block size used when a client doesn't negotiate one
+	defaultBlockSize = 512
+	// minBlockSize / maxBlockSize are the limits from RFC 2348
+	minBlockSize = 8
+	maxBlockSize = 65464
+	// defaultMaxWindowSize is the largest window that will be agreed with a client
+	defaultMaxWindowSize = 64
+	// maxRequestSize is the largest request packet that will be read
+	maxRequestSize = 1500
+)
+
+// ReaderFunc returns the contents of a file that has been requested, if the reader is also an io.Closer then it will
+// be closed once the transfer has finished.
+type ReaderFunc func(filename string) (io.Reader, error)
+
+// WriterFunc returns a writer for a file that a client is uploading, if the writer is also an io.Closer then it will
+// be closed once the transfer has finished.
+type WriterFunc func(filename string) (io.Writer, error)
+
+// Server is a TFTP server
+type Server struct {
+	ReadFunc  ReaderFunc
+	WriteFunc WriterFunc
+
+	// Timeout is how long to wait for a client before retransmitting, unless the client negotiates a timeout
+	Timeout time.Duration
+	// Retries is how many times a packet (or window of packets) is retransmitted before the transfer is abandoned
+	Retries int
+	// MaxBlockSize is the largest block size that will be agreed with a client
+	MaxBlockSize int
+	// MaxWindowSize is the largest window size that will be agreed with a client
+	MaxWindowSize int
+}
+
+// NewServer returns a new TFTP server that will use the functions to read and write files
+func NewServer(rf ReaderFunc, wf WriterFunc) *Server {
+	return &Server{
+		ReadFunc:      rf,
+		WriteFunc:     wf,
+		Timeout:       time.Second * 5,
+		Retries:       5,
+		MaxBlockSize:  maxBlockSize,
+		MaxWindowSize: defaultMaxWindowSize,
+	}
+}
+
+// Serve opens a UDP socket on the address and handles the requests it receives, each transfer takes place on its
+// own socket
+func (s *Server) Serve(addr string) error {
+	uaddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp4", uaddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		buf := make([]byte, maxRequestSize)
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		req, err := parseRequest(buf[:n])
+		if err != nil {
+			log.Debugf("TFTP bad request from [%s] -> %v", client.String(), err)
+			continue
+		}
+		go s.handleRequest(client, req)
+	}
+}
+
+// handleRequest will create a new socket for a transfer and then handle the read or write request
+func (s *Server) handleRequest(client *net.UDPAddr, req *request) {
+	conn, err := net.DialUDP("udp4", nil, client)
+	if err != nil {
+		log.Errorf("TFTP unable to connect to [%s] -> %v", client.String(), err)
+		return
+	}
+	defer conn.Close()
+
+	log.Debugf("TFTP request from [%s] for [%s] with options %v", client.String(), req.filename, req.options)
+
+	switch req.opcode {
+	case opRRQ:
+		err = s.handleRead(conn, req)
+	case opWRQ:
+		err = s.handleWrite(conn, req)
+	}
+	if err != nil {
+		log.Errorf("TFTP transfer of [%s] to [%s] failed -> %v", req.filename, client.String(), err)
+	}
+}
+
+// transfer holds the negotiated options and metrics of a single transfer
+type transfer struct {
+	conn       *net.UDPConn
+	blockSize  int
+	windowSize int
+	timeout    time.Duration
+	retries    int
+
+	bytes       int64
+	retransmits int
+	started     time.Time
+}
+
+// negotiate will determine which of the requested options are accepted, returning the options to acknowledge
+func (s *Server) negotiate(req *request, t *transfer, size int64) map[string]string {
+	accepted := map[string]string{}
+
+	if v, ok := req.options["blksize"]; ok {
+		if blockSize, err := strconv.Atoi(v); err == nil && blockSize >= minBlockSize {
+			if blockSize > s.MaxBlockSize {
+				blockSize = s.MaxBlockSize
+			}
+			t.blockSize = blockSize
+			accepted["blksize"] = strconv.Itoa(blockSize)
+		}
+	}
+
+	if v, ok := req.options["timeout"]; ok {
+		if timeout, err := strconv.Atoi(v); err == nil && timeout >= 1 && timeout <= 255 {
+			t.timeout = time.Duration(timeout) * time.Second
+			accepted["timeout"] = v
+		}
+	}
+
+	if _, ok := req.options["tsize"]; ok && req.opcode == opRRQ && size >= 0 {
+		accepted["tsize"] = strconv.FormatInt(size, 10)
+	}
+
+	if v, ok := req.options["windowsize"]; ok && req.opcode == opRRQ {
+		if windowSize, err := strconv.Atoi(v); err == nil && windowSize >= 1 && windowSize <= 65535 {
+			if windowSize > s.MaxWindowSize {
+				windowSize = s.MaxWindowSize
+			}
+			t.windowSize = windowSize
+			accepted["windowsize"] = strconv.Itoa(windowSize)
+		}
+	}
+	return accepted
+}
+
+// newTransfer creates a transfer with the server defaults
+func (s *Server) newTransfer(conn *net.UDPConn) *transfer {
+	return &transfer{
+		conn:       conn,
+		blockSize:  defaultBlockSize,
+		windowSize: 1,
+		timeout:    s.Timeout,
+		retries:    s.Retries,
+		started:    time.Now(),
+	}
+}
+
+// handleRead will send a file to a client
+func (s *Server) handleRead(conn *net.UDPConn, req *request) error {
+	if s.ReadFunc == nil {
+		conn.Write(errorPacket(errAccessViolation, "Reading files isn't supported"))
+		return fmt.Errorf("No read function")
+	}
+
+	r, err := s.ReadFunc(req.filename)
+	if err != nil {
+		code := errNotDefined
+		if errors.Is(err, os.ErrNotExist) {
+			code = errFileNotFound
+		} else if errors.Is(err, os.ErrPermission) {
+			code = errAccessViolation
+		}
+		conn.Write(errorPacket(code, err.Error()))
+		return err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	t := s.newTransfer(conn)
+	accepted := s.negotiate(req, t, readerSize(r))
+
+	// If any options are accepted then the client acknowledges them (with block 0) before the data is sent
+	if len(accepted) != 0 {
+		oack := oackPacket(req.order, accepted)
+		send := func() error {
+			_, err := conn.Write(oack)
+			return err
+		}
+		if err = send(); err != nil {
+			return err
+		}
+		err = t.waitForAck(send, func(block uint16) bool {
+			return block == 0
+		})
+		// Clients (such as iPXE and UEFI firmware) that only wanted the size of the file abort the transfer once it
+		// has been acknowledged, this is part of a normal boot rather than a failure
+		var cErr *clientError
+		if errors.As(err, &cErr) && (cErr.code == errOptionRefused || cErr.code == errNotDefined) {
+			log.Debugf("TFTP transfer of [%s] to [%s] ended by the client after the option acknowledgement -> %v",
+				req.filename, conn.RemoteAddr().String(), err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = t.sendFile(r)
+	if err != nil {
+		return err
+	}
+
+	duration := time.Since(t.started)
+	log.Infof("TFTP sent [%s] to [%s], %d bytes in %s (blksize %d, windowsize %d, %d retransmits)",
+		req.filename, conn.RemoteAddr().String(), t.bytes, duration.Round(time.Millisecond), t.blockSize, t.windowSize, t.retransmits)
+	return nil
+}
+
+// sendFile sends the file as a series of windows of blocks (RFC 7440), without a negotiated window size each window
+// is a single block which is the same as the lock-step transfer from RFC 1350
+func (t *transfer) sendFile(r io.Reader) error {
+	var window [][]byte
+	base := uint16(1) // The block number of the first block in the window
+	var eof, resend bool
+
+	sendWindow := func(first int) error {
+		for i := first; i < len(window); i++ {
+			if _, err := t.conn.Write(dataPacket(base+uint16(i), window[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		// Fill the window with the next blocks of the file, sending each new block (or the entire window if the
+		// client has only acknowledged part of it)
+		first := len(window)
+		if resend {
+			first = 0
+		}
+		for !eof && len(window) < t.windowSize {
+			buf := make([]byte, t.blockSize)
+			n, err := io.ReadFull(r, buf)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// A block smaller than the block size marks the end of the file
+				eof = true
+			} else if err != nil {
+				t.conn.Write(errorPacket(errNotDefined, err.Error()))
+				return err
+			}
+			window = append(window, buf[:n])
+		}
+		if err := sendWindow(first); err != nil {
+			return err
+		}
+
+		// Wait for an acknowledgement that moves the window along
+		var acked int
+		err := t.waitForAck(func() error {
+			return sendWindow(0)
+		}, func(block uint16) bool {
+			// Block numbers roll over, so the offset into the window is calculated with uint16 arithmetic
+			offset := int(block - base + 1)
+			if offset < 1 || offset > len(window) {
+				return false
+			}
+			acked = offset
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		// The client acknowledging part of the window means that the rest of it was lost
+		resend = acked < len(window)
+		if resend {
+			t.retransmits++
+		}
+
+		for _, b := range window[:acked] {
+			t.bytes += int64(len(b))
+		}
+		window = window[acked:]
+		base += uint16(acked)
+
+		if eof && len(window) == 0 {
+			return nil
+		}
+	}
+}
+
+// waitForAck will wait for an acknowledgement that is accepted, calling retransmit after each timeout
+func (t *transfer) waitForAck(retransmit func() error, accept func(block uint16) bool) error {
+	buf := make([]byte, maxRequestSize)
+	for attempt := 0; ; {
+		t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				attempt++
+				if attempt > t.retries {
+					return fmt.Errorf("Timed out after %d retransmits", t.retries)
+				}
+				t.retransmits++
+				if err = retransmit(); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		opcode, block, payload, err := parseReply(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch opcode {
+		case opACK:
+			// Duplicate or out of window acknowledgements are ignored, as retransmitting on them would cause
+			// the "Sorcerer's Apprentice" problem
+			if accept(block) {
+				return nil
+			}
+		case opERROR:
+			return &clientError{code: block, message: nullTerminated(payload)}
+		default:
+			t.conn.Write(errorPacket(errIllegalOperation, "Unexpected packet"))
+			return fmt.Errorf("Unexpected TFTP opcode [%d]", opcode)
+		}
+	}
+}
+
+// handleWrite will receive a file from a client, uploads are always a lock-step transfer
+func (s *Server) handleWrite(conn *net.UDPConn, req *request) error {
+	if s.WriteFunc == nil {
+		conn.Write(errorPacket(errAccessViolation, "Writing files isn't supported"))
+		return fmt.Errorf("No write function")
+	}
+	w, err := s.WriteFunc(req.filename)
+	if err != nil {
+		conn.Write(errorPacket(errAccessViolation, err.Error()))
+		return err
+	}
+	if c, ok := w.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	t := s.newTransfer(conn)
+	block := uint16(0)
+	ack := ackPacket(block)
+	if _, err := conn.Write(ack); err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxBlockSize+4)
+	for attempt := 0; ; {
+		conn.SetReadDeadline(time.Now().Add(t.timeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				attempt++
+				if attempt > t.retries {
+					return fmt.Errorf("Timed out after %d retransmits", t.retries)
+				}
+				t.retransmits++
+				conn.Write(ack)
+				continue
+			}
+			return err
+		}
+
+		opcode, number, payload, err := parseReply(buf[:n])
+		if err != nil {
+			continue
+		}
+		if opcode == opERROR {
+			return &clientError{code: number, message: nullTerminated(payload)}
+		}
+		if opcode != opDATA {
+			conn.Write(errorPacket(errIllegalOperation, "Unexpected packet"))
+			return fmt.Errorf("Unexpected TFTP opcode [%d]", opcode)
+		}
+		if number != block+1 {
+			// A retransmitted block, re-acknowledge the last block
+			conn.Write(ack)
+			continue
+		}
+
+		if _, err = w.Write(payload); err != nil {
+			conn.Write(errorPacket(errNotDefined, err.Error()))
+			return err
+		}
+		t.bytes += int64(len(payload))
+		block = number
+		attempt = 0
+		ack = ackPacket(block)
+		if _, err := conn.Write(ack); err != nil {
+			return err
+		}
+		if len(payload) < t.blockSize {
+			log.Infof("TFTP received [%s] from [%s], %d bytes in %s (%d retransmits)",
+				req.filename, conn.RemoteAddr().String(), t.bytes, time.Since(t.started).Round(time.Millisecond), t.retransmits)
+			return nil
+		}
+	}
+}
+
+// readerSize will attempt to find the size of a file that is being sent, -1 is returned if it is unknown
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case interface{ Stat() (os.FileInfo, error) }:
+		if info, err := v.Stat(); err == nil {
+			return info.Size()
+		}
+	}
+	return -1
+}
+
+// nullTerminated returns the string from a null terminated byte slice
+func nullTerminated(b []byte) string {
+	for i := range b {
+		if b[i] == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
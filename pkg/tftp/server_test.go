@@ -0,0 +1,352 @@
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		opcode         uint16
+		options        map[string]string
+		size           int64
+		wantAccepted   map[string]string
+		wantBlockSize  int
+		wantWindowSize int
+		wantTimeout    time.Duration
+	}{
+		{
+			name:           "no options",
+			options:        map[string]string{},
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "all options",
+			options:        map[string]string{"blksize": "1468", "tsize": "0", "timeout": "2", "windowsize": "16"},
+			size:           4096,
+			wantAccepted:   map[string]string{"blksize": "1468", "tsize": "4096", "timeout": "2", "windowsize": "16"},
+			wantBlockSize:  1468,
+			wantWindowSize: 16,
+			wantTimeout:    2 * time.Second,
+		},
+		{
+			name:           "blksize below the minimum",
+			options:        map[string]string{"blksize": "4"},
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "blksize that isn't a number",
+			options:        map[string]string{"blksize": "large"},
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "blksize above the maximum",
+			options:        map[string]string{"blksize": "70000"},
+			wantAccepted:   map[string]string{"blksize": "65464"},
+			wantBlockSize:  maxBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "windowsize of zero",
+			options:        map[string]string{"windowsize": "0"},
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "windowsize that isn't a number",
+			options:        map[string]string{"windowsize": "-"},
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "windowsize above the RFC limit",
+			options:        map[string]string{"windowsize": "65536"},
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "windowsize above the server maximum",
+			options:        map[string]string{"windowsize": "1000"},
+			wantAccepted:   map[string]string{"windowsize": "64"},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: defaultMaxWindowSize,
+		},
+		{
+			name:           "timeout out of range",
+			options:        map[string]string{"timeout": "0"},
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "tsize of a file with an unknown size",
+			options:        map[string]string{"tsize": "0"},
+			size:           -1,
+			wantAccepted:   map[string]string{},
+			wantBlockSize:  defaultBlockSize,
+			wantWindowSize: 1,
+		},
+		{
+			name:           "tsize and windowsize are only for reads",
+			opcode:         opWRQ,
+			options:        map[string]string{"tsize": "1024", "windowsize": "16", "blksize": "1024"},
+			wantAccepted:   map[string]string{"blksize": "1024"},
+			wantBlockSize:  1024,
+			wantWindowSize: 1,
+		},
+	}
+
+	s := NewServer(nil, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &request{opcode: opRRQ, options: tt.options}
+			if tt.opcode != 0 {
+				req.opcode = tt.opcode
+			}
+			tr := s.newTransfer(nil)
+			accepted := s.negotiate(req, tr, tt.size)
+
+			if !reflect.DeepEqual(accepted, tt.wantAccepted) {
+				t.Errorf("Accepted %v, expected %v", accepted, tt.wantAccepted)
+			}
+			if tr.blockSize != tt.wantBlockSize {
+				t.Errorf("Block size %d, expected %d", tr.blockSize, tt.wantBlockSize)
+			}
+			if tr.windowSize != tt.wantWindowSize {
+				t.Errorf("Window size %d, expected %d", tr.windowSize, tt.wantWindowSize)
+			}
+			wantTimeout := tt.wantTimeout
+			if wantTimeout == 0 {
+				wantTimeout = s.Timeout
+			}
+			if tr.timeout != wantTimeout {
+				t.Errorf("Timeout %s, expected %s", tr.timeout, wantTimeout)
+			}
+		})
+	}
+}
+
+// testClient is the client end of a transfer over the loopback interface, the server replies from the socket that it
+// creates for the transfer
+type testClient struct {
+	t      *testing.T
+	conn   *net.UDPConn
+	server *net.UDPAddr
+	result chan error
+}
+
+// newTestClient sends a read request to a server, the result of the transfer is returned through result
+func newTestClient(t *testing.T, s *Server, packet []byte) *testClient {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	req, err := parseRequest(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testClient{t: t, conn: conn, result: make(chan error, 1)}
+	go func() {
+		defer serverConn.Close()
+		c.result <- s.handleRead(serverConn, req)
+	}()
+	return c
+}
+
+// read returns the next packet from the server
+func (c *testClient) read() []byte {
+	buf := make([]byte, maxBlockSize+4)
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := c.conn.ReadFromUDP(buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.server = addr
+	return buf[:n]
+}
+
+// send writes a packet to the socket of the transfer
+func (c *testClient) send(b []byte) {
+	if _, err := c.conn.WriteToUDP(b, c.server); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// wait returns the result of the transfer from the server
+func (c *testClient) wait() error {
+	select {
+	case err := <-c.result:
+		return err
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("Timed out waiting for the transfer to finish")
+		return nil
+	}
+}
+
+// receive reads the file as a series of windows, acknowledging the last block of each one. The blocks that were
+// received are returned along with the file.
+func (c *testClient) receive(blockSize, windowSize int) ([]byte, int) {
+	var file bytes.Buffer
+	expected := uint16(1)
+	for blocks := 1; ; blocks++ {
+		opcode, block, payload, err := parseReply(c.read())
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if opcode != opDATA || block != expected {
+			c.t.Fatalf("Expected DATA block [%d], received opcode [%d] block [%d]", expected, opcode, block)
+		}
+		file.Write(payload)
+		expected++
+
+		if len(payload) < blockSize {
+			c.send(ackPacket(block))
+			return file.Bytes(), blocks
+		}
+		if blocks%windowSize == 0 {
+			c.send(ackPacket(block))
+		}
+	}
+}
+
+func TestReadTransfer(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		options    []string
+		blockSize  int
+		windowSize int
+		wantBlocks int
+	}{
+		{
+			name:       "lock-step without options",
+			size:       1300,
+			blockSize:  defaultBlockSize,
+			windowSize: 1,
+			wantBlocks: 3,
+		},
+		{
+			name:       "empty file",
+			size:       0,
+			blockSize:  defaultBlockSize,
+			windowSize: 1,
+			wantBlocks: 1,
+		},
+		{
+			// A file that fills its final block is followed by an empty block to mark the end of the file
+			name:       "size is a multiple of the block size",
+			size:       4 * 1024,
+			options:    []string{"blksize", "1024"},
+			blockSize:  1024,
+			windowSize: 1,
+			wantBlocks: 5,
+		},
+		{
+			name:       "size is a multiple of the window",
+			size:       8 * 512,
+			options:    []string{"windowsize", "4"},
+			blockSize:  defaultBlockSize,
+			windowSize: 4,
+			wantBlocks: 9,
+		},
+		{
+			// More than 65535 blocks, so the block number rolls over to 0 part way through a window
+			name:       "block number rolls over",
+			size:       70000*minBlockSize + 3,
+			options:    []string{"blksize", "8", "windowsize", "64"},
+			blockSize:  minBlockSize,
+			windowSize: 64,
+			wantBlocks: 70001,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.size)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			s := NewServer(func(filename string) (io.Reader, error) {
+				return bytes.NewReader(data), nil
+			}, nil)
+
+			c := newTestClient(t, s, testRequest(opRRQ, append([]string{"file", "octet"}, tt.options...)...))
+			if len(tt.options) != 0 {
+				// Every option is accepted with the value that was requested
+				oack := c.read()
+				if want := testRequest(opOACK, tt.options...); !bytes.Equal(oack, want) {
+					t.Fatalf("Received %q, expected the OACK %q", oack, want)
+				}
+				c.send(ackPacket(0))
+			}
+
+			file, blocks := c.receive(tt.blockSize, tt.windowSize)
+			if err := c.wait(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(file, data) {
+				t.Errorf("Received %d bytes that don't match the %d byte file", len(file), len(data))
+			}
+			if blocks != tt.wantBlocks {
+				t.Errorf("Received %d blocks, expected %d", blocks, tt.wantBlocks)
+			}
+		})
+	}
+}
+
+func TestReadErrorAfterOACK(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    uint16
+		wantErr bool
+	}{
+		// Clients that only wanted the size of the file end the transfer once the options are acknowledged
+		{name: "option refused", code: errOptionRefused},
+		{name: "not defined", code: errNotDefined},
+		{name: "access violation", code: errAccessViolation, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(func(filename string) (io.Reader, error) {
+				return bytes.NewReader(make([]byte, 2048)), nil
+			}, nil)
+
+			c := newTestClient(t, s, testRequest(opRRQ, "file", "octet", "tsize", "0"))
+			oack := c.read()
+			if want := testRequest(opOACK, "tsize", "2048"); !bytes.Equal(oack, want) {
+				t.Fatalf("Received %q, expected the OACK %q", oack, want)
+			}
+			c.send(errorPacket(tt.code, "Aborting"))
+
+			err := c.wait()
+			if tt.wantErr && err == nil {
+				t.Fatal("Expected the transfer to fail")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -1,16 +1,24 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"text/template"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// Static URL for retrieving the bootloader
-const iPXEURL = "https://boot.ipxe.org/undionly.kpxe"
+// Static URLs for retrieving the bootloaders for each architecture (and wimboot, for Windows deployments)
+var iPXEURLs = map[string]string{
+	"undionly.kpxe": "https://boot.ipxe.org/undionly.kpxe",
+	"ipxe.efi":      "https://boot.ipxe.org/ipxe.efi",
+	"snp.efi":       "https://boot.ipxe.org/arm64-efi/snp.efi",
+	"wimboot":       "https://github.com/ipxe/wimboot/releases/latest/download/wimboot",
+}
 
 // This header is used by all configurations
 const iPXEHeader = `#!ipxe
@@ -36,6 +44,30 @@ echo .`
 //
 //////////////////////////////
 
+// IPXEHeader - returns the header that begins every plunder iPXE script
+func IPXEHeader() string {
+	return iPXEHeader
+}
+
+// IPXETemplate - This will render a user supplied iPXE script template, the rendered script must begin with #!ipxe
+func IPXETemplate(name, script string, data interface{}) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(script)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse iPXE template [%s] -> %v", name, err)
+	}
+	var buffer bytes.Buffer
+	err = t.Execute(&buffer, data)
+	if err != nil {
+		return "", fmt.Errorf("Unable to render iPXE template [%s] -> %v", name, err)
+	}
+	// iPXE will only run a script if #!ipxe is the first line, so any whitespace left by template actions is removed
+	script = strings.TrimLeft(buffer.String(), " \t\r\n")
+	if !strings.HasPrefix(script, "#!ipxe") {
+		return "", fmt.Errorf("iPXE template [%s] must begin with #!ipxe", name)
+	}
+	return script, nil
+}
+
 // IPXEReboot -
 func IPXEReboot() string {
 	script := `
@@ -46,6 +78,17 @@ reboot
 	return iPXEHeader + script
 }
 
+// IPXELocalBoot - This will build an iPXE boot script that boots from the local disk, BIOS firmware boots the first
+// disk and UEFI firmware moves on to the next boot device
+func IPXELocalBoot() string {
+	script := `
+echo ${mac} has been installed, booting from the local disk
+iseq ${platform} pcbios && sanboot --no-describe --drive 0x80 ||
+exit
+`
+	return iPXEHeader + script
+}
+
 // IPXEAutoBoot -
 func IPXEAutoBoot() string {
 	script := `
@@ -107,6 +150,104 @@ boot
 	return iPXEHeader + buildScript
 }
 
+// IPXECloudInit - This will build an iPXE boot script for a cloud image, cloud-init reads its configuration from the
+// NoCloud datasource that plunder serves for the mac address
+func IPXECloudInit(webserverAddress, kernel, initrd, cmdline string) string {
+	script := `
+kernel http://%s/%s ds=nocloud-net;s=http://%s/${mac:hexhyp}/ %s
+initrd http://%s/%s
+boot
+`
+	// Replace the addresses inline
+	buildScript := fmt.Sprintf(script, webserverAddress, kernel, webserverAddress, cmdline, webserverAddress, initrd)
+
+	return iPXEHeader + buildScript
+}
+
+// IPXEAutoinstall - This will build an iPXE boot script for the Ubuntu (subiquity) live server installer, casper downloads
+// the ISO (if an ISO prefix is set) and the autoinstall configuration is read from the NoCloud datasource for the mac address
+func IPXEAutoinstall(webserverAddress, kernel, initrd, isoPrefix, cmdline string) string {
+	script := `
+kernel http://%s/%s ip=dhcp %s autoinstall ds=nocloud-net;s=http://%s/${mac:hexhyp}/ %s
+initrd http://%s/%s
+boot
+`
+	var isoURL string
+	if isoPrefix != "" {
+		isoURL = fmt.Sprintf("url=http://%s/%s.iso", webserverAddress, isoPrefix)
+	}
+
+	// Replace the addresses inline
+	buildScript := fmt.Sprintf(script, webserverAddress, kernel, isoURL, webserverAddress, cmdline, webserverAddress, initrd)
+
+	return iPXEHeader + buildScript
+}
+
+// IPXEIgnition - This will build an iPXE boot script for Fedora CoreOS (or RHCOS), the live system installs to a disk and
+// passes the Ignition configuration for the mac address to the installed system
+func IPXEIgnition(webserverAddress, kernel, initrd, installDevice, imageURL, cmdline string) string {
+	script := `
+kernel http://%s/%s initrd=main coreos.inst.install_dev=%s coreos.inst.ignition_url=http://%s/${mac:hexhyp}.ign %s %s
+initrd --name main http://%s/%s
+boot
+`
+	// If no image is set then newer releases install the image that is part of the live system
+	var image string
+	if imageURL != "" {
+		image = fmt.Sprintf("coreos.inst.image_url=%s", imageURL)
+	}
+
+	// Replace the addresses inline
+	buildScript := fmt.Sprintf(script, webserverAddress, kernel, installDevice, webserverAddress, image, cmdline, webserverAddress, initrd)
+
+	return iPXEHeader + buildScript
+}
+
+// IPXEAutoYaST - This will build an iPXE boot script for SUSE (SLES/openSUSE), the installer reads the AutoYaST profile
+// for the mac address and installs from the installation source (if it isn't set then install= should be in the cmdline)
+func IPXEAutoYaST(webserverAddress, kernel, initrd, installURL, cmdline string) string {
+	script := `
+kernel http://%s/%s autoyast=http://%s/${mac:hexhyp}.xml %s %s
+initrd http://%s/%s
+boot
+`
+	var install string
+	if installURL != "" {
+		install = fmt.Sprintf("install=%s", installURL)
+	}
+
+	// Replace the addresses inline
+	buildScript := fmt.Sprintf(script, webserverAddress, kernel, webserverAddress, install, cmdline, webserverAddress, initrd)
+
+	return iPXEHeader + buildScript
+}
+
+// IPXEWindows - This will build an iPXE boot script for Windows, wimboot loads the Windows boot manager, BCD and WinPE
+// (boot.wim) from the ISO prefix and adds the unattended installation files for the mac address to WinPE
+func IPXEWindows(webserverAddress, wimboot, isoPrefix, cmdline string) string {
+	script := `
+kernel http://%s/%s %s
+iseq ${platform} efi && set unattend autounattend.xml || set unattend autounattend-bios.xml
+initrd http://%s/%s/bootmgr bootmgr
+initrd http://%s/%s/boot/bcd BCD
+initrd http://%s/%s/boot/boot.sdi boot.sdi
+initrd http://%s/%s/sources/boot.wim boot.wim
+initrd http://%s/${mac:hexhyp}/${unattend} autounattend.xml
+initrd http://%s/${mac:hexhyp}/winpeshl.ini winpeshl.ini
+initrd http://%s/${mac:hexhyp}/install.cmd install.cmd
+boot
+`
+	// Replace the addresses inline
+	buildScript := fmt.Sprintf(script, webserverAddress, wimboot, cmdline,
+		webserverAddress, isoPrefix,
+		webserverAddress, isoPrefix,
+		webserverAddress, isoPrefix,
+		webserverAddress, isoPrefix,
+		webserverAddress, webserverAddress, webserverAddress)
+
+	return iPXEHeader + buildScript
+}
+
 // IPXEAnyBoot - This will build an iPXE boot script for anything wanting to PXE boot
 func IPXEAnyBoot(webserverAddress string, kernel, initrd, cmdline string) string {
 	script := `
@@ -120,19 +261,30 @@ boot
 	return iPXEHeader + buildScript
 }
 
-// PullPXEBooter - This will attempt to download the iPXE bootloader
+// PullPXEBooter - This will attempt to download the iPXE bootloaders
 func PullPXEBooter() error {
-	log.Infoln("Beginning of iPXE download... ")
+	for filename, url := range iPXEURLs {
+		log.Infof("Beginning of iPXE download [%s]... ", filename)
+		err := pullFile(filename, url)
+		if err != nil {
+			return err
+		}
+	}
+	log.Infoln("Completed")
+	return nil
+}
 
+// pullFile - This will download a url to a local file
+func pullFile(filename, url string) error {
 	// Create the file
-	out, err := os.Create("undionly.kpxe")
+	out, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
 	// Get the data
-	resp, err := http.Get(iPXEURL)
+	resp, err := http.Get(url)
 	if err != nil {
 		return err
 	}
@@ -140,9 +292,5 @@ func PullPXEBooter() error {
 
 	// Writer the body to file
 	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
-	}
-	log.Infoln("Completed")
-	return nil
+	return err
 }